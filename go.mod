@@ -3,8 +3,9 @@ module github.com/unanet/go/v2
 go 1.15
 
 require (
-	github.com/aws/aws-sdk-go v1.27.0
-	github.com/casbin/casbin/v2 v2.1.2
+	github.com/DATA-DOG/go-sqlmock v1.5.0
+	github.com/aws/aws-sdk-go v1.44.0
+	github.com/casbin/casbin/v2 v2.37.0
 	github.com/coreos/go-oidc/v3 v3.1.0
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.0.0 // indirect
 	github.com/go-chi/chi/v5 v5.0.4
@@ -18,10 +19,12 @@ require (
 	github.com/lestrrat-go/jwx v1.2.7 // indirect
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.11.1
+	github.com/prometheus/client_model v0.2.0
 	github.com/satori/go.uuid v1.2.0
 	github.com/stretchr/testify v1.7.0
 	go.uber.org/zap v1.16.0
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
 	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
+	k8s.io/apimachinery v0.18.2
 	k8s.io/client-go v0.18.2
 )
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+)
+
+type ctxKeyDecision int
+
+const decisionID ctxKeyDecision = 0
+
+// Resource is what an authorization decision is made for
+type Resource struct {
+	// Pattern is the chi route pattern, e.g. /orgs/{org}/users/{id}, or the URL path when the route is unknown
+	Pattern string
+	Path    string
+	Method  string
+	// Params holds the URL params of the route
+	Params map[string]string
+	// Claims holds the claims selected for the policies
+	Claims map[string]interface{}
+}
+
+// Decision records why a request was allowed or denied
+type Decision struct {
+	Allowed bool
+	Subject string
+	// Role is the role the allowing policy matched, empty when the request was denied
+	Role string
+	// Policy is the policy rule that allowed the request
+	Policy   []string
+	Resource Resource
+}
+
+func CtxWithDecision(ctx context.Context, d Decision) context.Context {
+	return context.WithValue(ctx, decisionID, d)
+}
+
+// GetDecision returns the authorization decision of the request ctx belongs to
+func GetDecision(ctx context.Context) (Decision, bool) {
+	if ctx == nil {
+		return Decision{}, false
+	}
+	d, ok := ctx.Value(decisionID).(Decision)
+	return d, ok
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+type ctxKeyPrincipal int
+
+const principalID ctxKeyPrincipal = 0
+
+// Principal is the authenticated user or service of a request
+type Principal struct {
+	Subject  string
+	Issuer   string
+	Audience []string
+	Email    string
+	Name     string
+	Roles    []string
+	Groups   []string
+	Scopes   []string
+	// ExpiresAt is zero when the token does not expire
+	ExpiresAt time.Time
+	Claims    jwt.MapClaims
+}
+
+// NewPrincipal reads the registered and the usual OIDC claims, roles are the roles claims were mapped to
+func NewPrincipal(claims jwt.MapClaims, roles []string) *Principal {
+	p := Principal{
+		Subject:  claimString(claims, "sub"),
+		Issuer:   claimString(claims, "iss"),
+		Audience: claimStrings(claims["aud"]),
+		Email:    claimString(claims, "email"),
+		Name:     claimString(claims, "name"),
+		Roles:    roles,
+		Groups:   claimStrings(claims["groups"]),
+		Scopes:   claimStrings(claims["scope"]),
+		Claims:   claims,
+	}
+	if p.Name == "" {
+		p.Name = claimString(claims, "preferred_username")
+	}
+	if len(p.Scopes) == 0 {
+		p.Scopes = claimStrings(claims["scp"])
+	}
+	p.ExpiresAt = claimTime(claims["exp"])
+	return &p
+}
+
+func CtxWithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalID, p)
+}
+
+// GetPrincipal returns the Principal of the request ctx belongs to, built from the claims and roles of ctx when the
+// auth middleware did not record one. It returns false for unauthenticated requests.
+func GetPrincipal(ctx context.Context) (*Principal, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	if p, ok := ctx.Value(principalID).(*Principal); ok {
+		return p, true
+	}
+	claims := Claims(ctx)
+	if len(claims) == 0 {
+		return nil, false
+	}
+	return NewPrincipal(claims, Roles(ctx)), true
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+func (p *Principal) InGroup(group string) bool {
+	return contains(p.Groups, group)
+}
+
+// RequireRole returns a 403 RestError unless p has one of roles
+func (p *Principal) RequireRole(roles ...string) error {
+	for _, role := range roles {
+		if p.HasRole(role) {
+			return nil
+		}
+	}
+	return errors.NewRestError(http.StatusForbidden, "Forbidden")
+}
+
+// RequireScope returns a 403 RestError unless p has one of scopes
+func (p *Principal) RequireScope(scopes ...string) error {
+	for _, scope := range scopes {
+		if p.HasScope(scope) {
+			return nil
+		}
+	}
+	return errors.NewRestError(http.StatusForbidden, "Forbidden")
+}
+
+// Decode decodes the claims into v, a pointer to a struct with json tags
+func (p *Principal) Decode(v interface{}) error {
+	b, err := json.Marshal(p.Claims)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode claims")
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return errors.Wrap(err, "failed to decode claims")
+	}
+	return nil
+}
+
+// RequireRole returns a 401 RestError for unauthenticated requests and a 403 RestError unless the Principal of ctx has
+// one of roles
+func RequireRole(ctx context.Context, roles ...string) error {
+	p, ok := GetPrincipal(ctx)
+	if !ok {
+		return errors.ErrUnauthorized
+	}
+	return p.RequireRole(roles...)
+}
+
+// DecodeClaims decodes the claims of ctx into v, a pointer to a struct with json tags, e.g.
+//
+//	var c struct {
+//		Tenant string `json:"tenant"`
+//	}
+//	err := auth.DecodeClaims(ctx, &c)
+func DecodeClaims(ctx context.Context, v interface{}) error {
+	p, ok := GetPrincipal(ctx)
+	if !ok {
+		return errors.ErrUnauthorized
+	}
+	return p.Decode(v)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+// claimTime reads a NumericDate claim, whose type depends on how the claims were decoded
+func claimTime(v interface{}) time.Time {
+	var seconds float64
+	switch v := v.(type) {
+	case float64:
+		seconds = v
+	case int64:
+		seconds = float64(v)
+	case int:
+		seconds = float64(v)
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}
+		}
+		seconds = f
+	case *jwt.NumericDate:
+		if v == nil {
+			return time.Time{}
+		}
+		return v.Time
+	case time.Time:
+		return v
+	default:
+		return time.Time{}
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+func TestPrincipal(t *testing.T) {
+	claims := jwt.MapClaims{
+		"sub":                "1234",
+		"iss":                "https://idp.example.com",
+		"aud":                "api",
+		"email":              "jdoe@example.com",
+		"preferred_username": "jdoe",
+		"groups":             []interface{}{"finance"},
+		"scp":                []interface{}{"invoices.read"},
+		"exp":                float64(1609459200),
+		"tenant":             "acme",
+		"realm_access":       map[string]interface{}{"roles": []interface{}{"reader"}},
+	}
+
+	_, ok := GetPrincipal(context.Background())
+	require.False(t, ok)
+	require.Equal(t, errors.ErrUnauthorized, RequireRole(context.Background(), "reader"))
+
+	ctx := CtxWithClaims(context.Background(), claims)
+	p, ok := GetPrincipal(ctx)
+	require.True(t, ok)
+	require.Equal(t, "1234", p.Subject)
+	require.Equal(t, "https://idp.example.com", p.Issuer)
+	require.Equal(t, []string{"api"}, p.Audience)
+	require.Equal(t, "jdoe@example.com", p.Email)
+	require.Equal(t, "jdoe", p.Name)
+	require.Equal(t, []string{"reader"}, p.Roles)
+	require.True(t, p.InGroup("finance"))
+	require.True(t, p.HasScope("invoices.read"))
+	require.False(t, p.HasScope("invoices.write"))
+	require.True(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).Equal(p.ExpiresAt))
+
+	require.NoError(t, RequireRole(ctx, "admin", "reader"))
+	require.Error(t, p.RequireRole("admin"))
+	require.NoError(t, p.RequireScope("invoices.read"))
+
+	var custom struct {
+		Tenant string `json:"tenant"`
+		Email  string `json:"email"`
+	}
+	require.NoError(t, DecodeClaims(ctx, &custom))
+	require.Equal(t, "acme", custom.Tenant)
+	require.Equal(t, "jdoe@example.com", custom.Email)
+
+	recorded := NewPrincipal(jwt.MapClaims{"sub": "svc"}, []string{"writer"})
+	p, ok = GetPrincipal(CtxWithPrincipal(ctx, recorded))
+	require.True(t, ok)
+	require.Equal(t, recorded, p)
+	require.True(t, p.HasRole("writer"))
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+type ctxKeyRoles int
+
+const rolesID ctxKeyRoles = 0
+
+// RoleSource is a claim holding roles, either as an array or as a space separated string
+type RoleSource struct {
+	// Path is the location of the claim, e.g. groups, realm_access.roles or $.resource_access["my.app"].roles
+	Path string `json:"path"`
+	// Prefix is prepended to the roles found at Path
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// ResourceRoles returns the RoleSource of the roles Keycloak grants for client
+func ResourceRoles(client string, prefix string) RoleSource {
+	return RoleSource{
+		Path:   fmt.Sprintf("resource_access[%q].roles", client),
+		Prefix: prefix,
+	}
+}
+
+// RoleMapping extracts the roles of a token from its claims. Roles are read from the Sources and mapped from the
+// scopes of the token, then renamed.
+type RoleMapping struct {
+	Sources []RoleSource `json:"sources"`
+	// Scopes maps scopes to the roles they grant
+	Scopes map[string][]string `json:"scopes,omitempty"`
+	// ScopeClaims are the claims holding the scopes, defaults to scope and scp
+	ScopeClaims []string `json:"scope_claims,omitempty"`
+	// Rename maps a role to another name, an empty name drops the role
+	Rename map[string]string `json:"rename,omitempty"`
+}
+
+// DefaultRoleMapping reads the realm roles of Keycloak tokens
+var DefaultRoleMapping = RoleMapping{
+	Sources: []RoleSource{{Path: "realm_access.roles"}},
+}
+
+// Decode implements envconfig.Decoder
+func (m *RoleMapping) Decode(value string) error {
+	var mapping RoleMapping
+	if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+		return errors.Wrap(err, "invalid role mapping")
+	}
+	for _, s := range mapping.Sources {
+		if _, err := parseClaimPath(s.Path); err != nil {
+			return err
+		}
+	}
+	*m = mapping
+	return nil
+}
+
+// Roles returns the roles claims grant, without duplicates
+func (m RoleMapping) Roles(claims jwt.MapClaims) []string {
+	var roles []string
+	seen := make(map[string]bool)
+	add := func(role string) {
+		if renamed, ok := m.Rename[role]; ok {
+			role = renamed
+		}
+		if role != "" && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+
+	for _, s := range m.Sources {
+		path, err := parseClaimPath(s.Path)
+		if err != nil {
+			continue
+		}
+		for _, role := range claimStrings(lookupClaim(claims, path)) {
+			add(s.Prefix + role)
+		}
+	}
+
+	if len(m.Scopes) > 0 {
+		scopeClaims := m.ScopeClaims
+		if len(scopeClaims) == 0 {
+			scopeClaims = []string{"scope", "scp"}
+		}
+		for _, c := range scopeClaims {
+			for _, scope := range claimStrings(claims[c]) {
+				for _, role := range m.Scopes[scope] {
+					add(role)
+				}
+			}
+		}
+	}
+
+	return roles
+}
+
+// CtxWithRoles records the roles of the request, AuthenticationMiddleware records the roles it authorized with
+func CtxWithRoles(ctx context.Context, roles []string) context.Context {
+	return context.WithValue(ctx, rolesID, roles)
+}
+
+// Roles returns the roles recorded in ctx, or the roles DefaultRoleMapping extracts from the claims of ctx
+func Roles(ctx context.Context) []string {
+	if ctx == nil {
+		return nil
+	}
+	if roles, ok := ctx.Value(rolesID).([]string); ok {
+		return roles
+	}
+	return DefaultRoleMapping.Roles(Claims(ctx))
+}
+
+// parseClaimPath splits a path like $.a.b["c.d"] into its keys
+func parseClaimPath(path string) ([]string, error) {
+	p := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	var keys []string
+	for len(p) > 0 {
+		switch {
+		case p[0] == '[':
+			end := strings.Index(p, "]")
+			if end < 0 {
+				return nil, errors.Wrapf("invalid claim path %s", path)
+			}
+			key := p[1:end]
+			if len(key) < 2 || (key[0] != '"' && key[0] != '\'') || key[len(key)-1] != key[0] {
+				return nil, errors.Wrapf("invalid claim path %s", path)
+			}
+			keys = append(keys, key[1:len(key)-1])
+			p = strings.TrimPrefix(p[end+1:], ".")
+		default:
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			if end == 0 {
+				return nil, errors.Wrapf("invalid claim path %s", path)
+			}
+			keys = append(keys, p[:end])
+			p = strings.TrimPrefix(p[end:], ".")
+		}
+	}
+	if len(keys) == 0 {
+		return nil, errors.Wrapf("invalid claim path %s", path)
+	}
+	return keys, nil
+}
+
+func lookupClaim(claims map[string]interface{}, path []string) interface{} {
+	var v interface{} = claims
+	for _, key := range path {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+// claimStrings returns the strings of an array claim, or the words of a string claim
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []string:
+		return v
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, x := range v {
+			if s, ok := x.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
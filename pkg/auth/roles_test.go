@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoleMapping(t *testing.T) {
+	claims := jwt.MapClaims{
+		"realm_access": map[string]interface{}{"roles": []interface{}{"admin", "offline_access"}},
+		"resource_access": map[string]interface{}{
+			"billing.app": map[string]interface{}{"roles": []interface{}{"writer"}},
+		},
+		"groups": []interface{}{"Finance", "admin"},
+		"scp":    "invoices.read profile",
+	}
+
+	require.Equal(t, []string{"admin", "offline_access"}, DefaultRoleMapping.Roles(claims))
+
+	var m RoleMapping
+	require.NoError(t, m.Decode(`{
+		"sources": [{"path": "$.realm_access.roles"}, {"path": "groups", "prefix": "group:"}],
+		"scopes": {"invoices.read": ["reader"], "invoices.write": ["writer"]},
+		"rename": {"group:Finance": "finance", "offline_access": ""}
+	}`))
+	m.Sources = append(m.Sources, ResourceRoles("billing.app", "billing:"))
+
+	require.Equal(t, []string{"admin", "finance", "group:admin", "billing:writer", "reader"}, m.Roles(claims))
+
+	require.Error(t, m.Decode(`{"sources": [{"path": "a[b]"}]}`))
+	require.Empty(t, RoleMapping{Sources: []RoleSource{{Path: "groups.x"}}}.Roles(claims))
+}
+
+func TestRoles(t *testing.T) {
+	ctx := CtxWithClaims(context.Background(), jwt.MapClaims{
+		"realm_access": map[string]interface{}{"roles": []interface{}{"admin"}},
+	})
+	require.Equal(t, []string{"admin"}, Roles(ctx))
+	require.Equal(t, []string{"reader"}, Roles(CtxWithRoles(ctx, []string{"reader"})))
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	goErrors "errors"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+const serviceKeyHashPrefix = "sha256:"
+
+// ErrUnknownServiceKey is returned by ServiceKeys.Authenticate for keys that are not service keys, e.g. JWTs
+var ErrUnknownServiceKey = goErrors.New("unknown service key")
+
+// ServiceKey is an API key of a service, only the hash of the key is kept
+type ServiceKey struct {
+	Name string `json:"name"`
+	// Hash is the HashServiceKey of the key
+	Hash  string   `json:"hash"`
+	Roles []string `json:"roles"`
+	// ExpiresAt is optional, the key is rejected from then on
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func (k ServiceKey) expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// HashServiceKey returns the hash a ServiceKey stores for key. Service keys are random, so a fast hash is enough.
+func HashServiceKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return serviceKeyHashPrefix + hex.EncodeToString(sum[:])
+}
+
+// ServiceKeyList holds service keys as JSON, e.g.
+//
+//	[{"name": "billing", "hash": "sha256:9f86d0...", "roles": ["reader"], "expires_at": "2022-01-01T00:00:00Z"}]
+type ServiceKeyList []ServiceKey
+
+// Decode implements envconfig.Decoder
+func (l *ServiceKeyList) Decode(value string) error {
+	var keys []ServiceKey
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return errors.Wrap(err, "invalid service keys")
+	}
+	*l = keys
+	return nil
+}
+
+// ServiceKeysConfig loads service keys from the environment and from a file holding a ServiceKeyList
+type ServiceKeysConfig struct {
+	ServiceKeys     ServiceKeyList `split_words:"true"`
+	ServiceKeysFile string         `split_words:"true"`
+}
+
+// ServiceKeys authenticates requests of services by their API key. Keys are rotated by adding the new key and letting
+// the old one expire once the services switched, see Rotate.
+type ServiceKeys struct {
+	mu   sync.RWMutex
+	keys map[string]ServiceKey
+}
+
+func NewServiceKeys(keys ...ServiceKey) (*ServiceKeys, error) {
+	s := ServiceKeys{
+		keys: make(map[string]ServiceKey),
+	}
+	for _, k := range keys {
+		if err := s.Add(k); err != nil {
+			return nil, err
+		}
+	}
+	return &s, nil
+}
+
+// LoadServiceKeys creates ServiceKeys holding the keys of the environment and of the file of c
+func LoadServiceKeys(c ServiceKeysConfig) (*ServiceKeys, error) {
+	keys := append(ServiceKeyList{}, c.ServiceKeys...)
+	if c.ServiceKeysFile != "" {
+		b, err := ioutil.ReadFile(c.ServiceKeysFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read service keys")
+		}
+		var fromFile ServiceKeyList
+		if err := fromFile.Decode(string(b)); err != nil {
+			return nil, errors.Wrap(err, c.ServiceKeysFile)
+		}
+		keys = append(keys, fromFile...)
+	}
+	return NewServiceKeys(keys...)
+}
+
+func (s *ServiceKeys) Add(k ServiceKey) error {
+	if k.Name == "" {
+		return errors.Wrapf("service key without name")
+	}
+	if !strings.HasPrefix(k.Hash, serviceKeyHashPrefix) || len(k.Hash) != len(serviceKeyHashPrefix)+2*sha256.Size {
+		return errors.Wrapf("service key %s: hash has to be a HashServiceKey", k.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.keys[k.Hash]; ok && existing.Name != k.Name {
+		return errors.Wrapf("service key %s: hash already used by %s", k.Name, existing.Name)
+	}
+	s.keys[k.Hash] = k
+	return nil
+}
+
+// Rotate adds the key hash for name with the roles of the current keys of name, which keep working for overlap
+func (s *ServiceKeys) Rotate(name string, hash string, overlap time.Duration) error {
+	s.mu.Lock()
+	expiresAt := time.Now().Add(overlap)
+	var roles []string
+	found := false
+	for h, k := range s.keys {
+		if k.Name != name {
+			continue
+		}
+		if !found || k.ExpiresAt == nil {
+			roles = k.Roles
+		}
+		found = true
+		if k.ExpiresAt == nil || k.ExpiresAt.After(expiresAt) {
+			k.ExpiresAt = &expiresAt
+			s.keys[h] = k
+		}
+	}
+	s.mu.Unlock()
+
+	if !found {
+		return errors.Wrapf("service key %s not found", name)
+	}
+	return s.Add(ServiceKey{Name: name, Hash: hash, Roles: roles})
+}
+
+// Remove removes all keys of name
+func (s *ServiceKeys) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for h, k := range s.keys {
+		if k.Name == name {
+			delete(s.keys, h)
+		}
+	}
+}
+
+// Authenticate returns the ServiceKey of key, ErrUnknownServiceKey when there is none and errors.ErrExpired when it
+// expired
+func (s *ServiceKeys) Authenticate(key string) (ServiceKey, error) {
+	if key == "" {
+		return ServiceKey{}, ErrUnknownServiceKey
+	}
+
+	s.mu.RLock()
+	k, ok := s.keys[HashServiceKey(key)]
+	s.mu.RUnlock()
+	if !ok {
+		return ServiceKey{}, ErrUnknownServiceKey
+	}
+	if k.expired(time.Now()) {
+		return k, errors.ErrExpired
+	}
+	return k, nil
+}
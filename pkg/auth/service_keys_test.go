@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+func TestServiceKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "service-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "keys.json")
+	require.NoError(t, ioutil.WriteFile(file, []byte(`[{"name": "reports", "hash": "`+HashServiceKey("reports-key")+`", "roles": ["reader"]}]`), 0600))
+
+	var c ServiceKeysConfig
+	require.NoError(t, c.ServiceKeys.Decode(`[
+		{"name": "billing", "hash": "`+HashServiceKey("billing-key")+`", "roles": ["writer"]},
+		{"name": "legacy", "hash": "`+HashServiceKey("legacy-key")+`", "expires_at": "2020-01-01T00:00:00Z"}
+	]`))
+	c.ServiceKeysFile = file
+
+	keys, err := LoadServiceKeys(c)
+	require.NoError(t, err)
+
+	k, err := keys.Authenticate("billing-key")
+	require.NoError(t, err)
+	require.Equal(t, "billing", k.Name)
+	require.Equal(t, []string{"writer"}, k.Roles)
+
+	k, err = keys.Authenticate("reports-key")
+	require.NoError(t, err)
+	require.Equal(t, "reports", k.Name)
+
+	_, err = keys.Authenticate("legacy-key")
+	require.Equal(t, errors.ErrExpired, err)
+
+	_, err = keys.Authenticate("some.jwt.token")
+	require.Equal(t, ErrUnknownServiceKey, err)
+
+	require.NoError(t, keys.Rotate("billing", HashServiceKey("billing-key-2"), time.Hour))
+	k, err = keys.Authenticate("billing-key")
+	require.NoError(t, err, "the old key works during the overlap")
+	require.NotNil(t, k.ExpiresAt)
+	require.WithinDuration(t, time.Now().Add(time.Hour), *k.ExpiresAt, time.Minute)
+	k, err = keys.Authenticate("billing-key-2")
+	require.NoError(t, err)
+	require.Equal(t, []string{"writer"}, k.Roles)
+	require.Nil(t, k.ExpiresAt)
+
+	require.NoError(t, keys.Rotate("billing", HashServiceKey("billing-key-3"), 0))
+	_, err = keys.Authenticate("billing-key-2")
+	require.Equal(t, errors.ErrExpired, err)
+
+	require.Error(t, keys.Rotate("unknown", HashServiceKey("x"), 0))
+	require.Error(t, keys.Add(ServiceKey{Name: "plain", Hash: "billing-key"}))
+	require.Error(t, keys.Add(ServiceKey{Name: "other", Hash: HashServiceKey("reports-key")}))
+
+	keys.Remove("reports")
+	_, err = keys.Authenticate("reports-key")
+	require.Equal(t, ErrUnknownServiceKey, err)
+}
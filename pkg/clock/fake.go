@@ -0,0 +1,137 @@
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fake is a Clock that only moves when Advance or Set is called. Timers, After and Sleep fire once the fake time
+// reaches their deadline.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+func NewFake(now time.Time) *Fake {
+	f := Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return &f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.NewTimer(d).C()
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1)}
+	f.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward by d and fires every timer that is due, in deadline order
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(f.now.Add(d))
+}
+
+// Set moves the clock to t, which must not be before the current fake time
+func (f *Fake) Set(t time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(t)
+}
+
+// BlockUntil waits until at least n timers are pending, which lets a test wait for the code under test to start
+// waiting before it advances the clock
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) set(t time.Time) {
+	f.now = t
+
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].at.Before(f.waiters[j].at)
+	})
+	var pending []*fakeTimer
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		select {
+		case w.c <- w.at:
+		default:
+		}
+	}
+	f.waiters = pending
+}
+
+func (f *Fake) schedule(t *fakeTimer, d time.Duration) {
+	t.at = f.now.Add(d)
+	if d <= 0 {
+		select {
+		case t.c <- f.now:
+		default:
+		}
+		return
+	}
+	f.waiters = append(f.waiters, t)
+	f.cond.Broadcast()
+}
+
+// unschedule removes t from the pending timers and reports whether it was pending
+func (f *Fake) unschedule(t *fakeTimer) bool {
+	for i, w := range f.waiters {
+		if w == t {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	f  *Fake
+	c  chan time.Time
+	at time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	return t.f.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	pending := t.f.unschedule(t)
+	t.f.schedule(t, d)
+	return pending
+}
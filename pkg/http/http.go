@@ -2,11 +2,17 @@ package http
 
 import (
 	"context"
+	"math/rand"
 	"net/http"
+	"sync"
 
 	"go.uber.org/zap"
 
+	"github.com/unanet/go/v2/pkg/clock"
+	"github.com/unanet/go/v2/pkg/errors"
 	"github.com/unanet/go/v2/pkg/log"
+	"github.com/unanet/go/v2/pkg/ratelimit"
+	"github.com/unanet/go/v2/pkg/retry"
 )
 
 // Transport implements http.RoundTripper. When set as Transport of http.Client, it executes HTTP requests with logging.
@@ -15,6 +21,21 @@ type Transport struct {
 	Transport   http.RoundTripper
 	LogRequest  func(req *http.Request)
 	LogResponse func(resp *http.Response)
+	// Breakers fails requests fast with retry.ErrBreakerOpen while the breaker of their host is open. Errors and 5xx
+	// responses count as failures, cancelled requests are not counted.
+	Breakers *retry.Breakers
+	// DefaultPolicy retries and hedges idempotent requests to hosts that have no entry in Policies
+	DefaultPolicy *Policy
+	Policies      map[string]*Policy
+	Clock         clock.Clock
+	// Rand draws the jitter of the retry delays instead of the package level source of math/rand
+	Rand *rand.Rand
+	// Limiter delays every attempt until the limit of its key, the host unless LimitKey is set, allows it
+	Limiter  ratelimit.Limiter
+	LimitKey func(req *http.Request) string
+
+	mu            sync.Mutex
+	hostLatencies map[string]*latencies
 }
 
 // THe default logging transport that wraps http.DefaultTransport.
@@ -78,8 +99,32 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 	req.Header.Add(log.RequestIDHeader, reqID)
 
+	var done func(outcome retry.Outcome)
+	if t.Breakers != nil {
+		var err error
+		done, err = t.Breakers.Get(req.URL.Host).Allow()
+		if err != nil {
+			return nil, errors.Wrap(err, req.URL.Host)
+		}
+	}
+
 	t.logRequest(req)
-	resp, err := t.transport().RoundTrip(req)
+	var resp *http.Response
+	var err error
+	if done != nil {
+		// a panicking transport counts as a failure, a cancelled request as no outcome
+		outcome := retry.OutcomeFailure
+		defer func() {
+			done(outcome)
+		}()
+		resp, err = t.send(req)
+		outcome = retry.OutcomeOf(err)
+		if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+			outcome = retry.OutcomeFailure
+		}
+	} else {
+		resp, err = t.send(req)
+	}
 	if err != nil {
 		return resp, err
 	}
@@ -112,3 +157,17 @@ func (t *Transport) transport() http.RoundTripper {
 
 	return http.DefaultTransport
 }
+
+// roundTrip sends a single attempt of req once the Limiter allows it
+func (t *Transport) roundTrip(req *http.Request) (*http.Response, error) {
+	if t.Limiter != nil {
+		key := req.URL.Host
+		if t.LimitKey != nil {
+			key = t.LimitKey(req)
+		}
+		if err := t.Limiter.Wait(req.Context(), key); err != nil {
+			return nil, errors.Wrap(err, key)
+		}
+	}
+	return t.transport().RoundTrip(req)
+}
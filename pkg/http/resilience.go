@@ -0,0 +1,418 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	goErrors "errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/unanet/go/v2/pkg/clock"
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+// Policy configures retries and hedging of idempotent requests to a host. Requests are idempotent when their method
+// is, or when they carry an Idempotency-Key header.
+type Policy struct {
+	// MaxRetries is the number of times a request is retried after a transport error or a RetryStatuses response
+	MaxRetries int
+	// InitialDelay and MaxDelay bound the exponential, fully jittered delay between retries, InitialDelay defaults to
+	// 100ms
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	// RetryStatuses defaults to 429, 502, 503 and 504
+	RetryStatuses []int
+	// MaxRetryAfter is the longest Retry-After the Transport waits for, responses asking for longer are returned.
+	// Defaults to 30s.
+	MaxRetryAfter time.Duration
+	// Budget limits retries to a share of the requests, nil allows every retry
+	Budget *RetryBudget
+
+	// HedgePercentile sends a second attempt when the first one did not respond within this percentile of the
+	// recent latencies of the host, e.g. 0.95. The first response wins and the other attempt is cancelled.
+	HedgePercentile float64
+	// HedgeMinSamples is the number of latencies recorded before hedging starts, defaults to 20
+	HedgeMinSamples int
+}
+
+const (
+	defaultInitialDelay  = 100 * time.Millisecond
+	defaultMaxRetryAfter = 30 * time.Second
+)
+
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryBudget is a token bucket that every request adds Ratio tokens to and every retry takes one token from, so
+// retries can't exceed Ratio of the traffic once the initial tokens are spent
+type RetryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	max    float64
+	tokens float64
+}
+
+// NewRetryBudget creates a RetryBudget holding up to max tokens, it starts full
+func NewRetryBudget(ratio float64, max float64) *RetryBudget {
+	return &RetryBudget{
+		ratio:  ratio,
+		max:    max,
+		tokens: max,
+	}
+}
+
+func (b *RetryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = math.Min(b.max, b.tokens+b.ratio)
+}
+
+func (b *RetryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+const latencySamples = 100
+
+// latencies keeps the most recent response times of a host
+type latencies struct {
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencies) record(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.samples) < latencySamples {
+		l.samples = append(l.samples, d)
+		return
+	}
+	l.samples[l.next] = d
+	l.next = (l.next + 1) % latencySamples
+}
+
+func (l *latencies) percentile(p float64, min int) (time.Duration, bool) {
+	l.mu.Lock()
+	sorted := append([]time.Duration(nil), l.samples...)
+	l.mu.Unlock()
+
+	if len(sorted) == 0 || len(sorted) < min {
+		return 0, false
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i], true
+}
+
+func (t *Transport) policy(host string) *Policy {
+	if p, ok := t.Policies[host]; ok {
+		return p
+	}
+	return t.DefaultPolicy
+}
+
+func (t *Transport) clock() clock.Clock {
+	if t.Clock != nil {
+		return t.Clock
+	}
+	return clock.Real
+}
+
+func (t *Transport) latencies(host string) *latencies {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.hostLatencies == nil {
+		t.hostLatencies = make(map[string]*latencies)
+	}
+	l, ok := t.hostLatencies[host]
+	if !ok {
+		l = &latencies{}
+		t.hostLatencies[host] = l
+	}
+	return l
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+// replayable returns a copy of req whose body can be read again through GetBody, buffering the body if needed
+func replayable(req *http.Request) (*http.Request, error) {
+	req = req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return req, nil
+	}
+
+	b, err := ioutil.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	req.Body, _ = req.GetBody()
+	return req, nil
+}
+
+// rewind returns a copy of req with a fresh body for another attempt
+func rewind(ctx context.Context, req *http.Request) (*http.Request, error) {
+	r := req.Clone(ctx)
+	if req.GetBody != nil && req.Body != nil && req.Body != http.NoBody {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		r.Body = body
+	}
+	return r, nil
+}
+
+// send runs the request through the retry and hedging Policy of its host
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	p := t.policy(req.URL.Host)
+	if p == nil || !isIdempotent(req) {
+		return t.roundTrip(req)
+	}
+
+	req, err := replayable(req)
+	if err != nil {
+		return nil, err
+	}
+	if p.Budget != nil {
+		p.Budget.deposit()
+	}
+
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		r, err := rewind(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := t.hedge(p, r)
+
+		delay, ok := t.retryDelay(p, attempt, resp, err)
+		if !ok || attempt >= p.MaxRetries || ctx.Err() != nil {
+			return resp, err
+		}
+		if p.Budget != nil && !p.Budget.withdraw() {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		timer := t.clock().NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C():
+		}
+	}
+}
+
+// retryDelay reports whether the outcome of an attempt should be retried and how long to wait before doing so
+func (t *Transport) retryDelay(p *Policy, attempt int, resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		if goErrors.Is(err, context.Canceled) || goErrors.Is(err, context.DeadlineExceeded) {
+			return 0, false
+		}
+		return t.backoff(p, attempt), true
+	}
+
+	statuses := p.RetryStatuses
+	if statuses == nil {
+		statuses = defaultRetryStatuses
+	}
+	retryable := false
+	for _, s := range statuses {
+		if resp.StatusCode == s {
+			retryable = true
+			break
+		}
+	}
+	if !retryable {
+		return 0, false
+	}
+
+	if d, ok := retryAfter(resp.Header.Get("Retry-After"), t.clock().Now()); ok {
+		max := p.MaxRetryAfter
+		if max <= 0 {
+			max = defaultMaxRetryAfter
+		}
+		if d > max {
+			return 0, false
+		}
+		return d, true
+	}
+	return t.backoff(p, attempt), true
+}
+
+func (t *Transport) backoff(p *Policy, attempt int) time.Duration {
+	initial := p.InitialDelay
+	if initial <= 0 {
+		initial = defaultInitialDelay
+	}
+	d := float64(initial) * math.Pow(2, float64(attempt))
+	if p.MaxDelay > 0 && d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	return time.Duration(t.random() * d)
+}
+
+func (t *Transport) random() float64 {
+	if t.Rand == nil {
+		return rand.Float64()
+	}
+	// a rand.Rand is not safe for concurrent use
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.Rand.Float64()
+}
+
+// retryAfter parses a Retry-After header holding either a number of seconds or an HTTP date
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(v); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+type attemptResult struct {
+	id   int
+	resp *http.Response
+	err  error
+}
+
+// hedge sends req and, when the Policy hedges and the host is slower than usual, a second copy of it. The first
+// response is returned and the other attempt cancelled, an attempt failing with an error leaves the other to answer.
+func (t *Transport) hedge(p *Policy, req *http.Request) (*http.Response, error) {
+	l := t.latencies(req.URL.Host)
+	min := p.HedgeMinSamples
+	if min == 0 {
+		min = 20
+	}
+
+	var after time.Duration
+	var hedging bool
+	if p.HedgePercentile > 0 {
+		after, hedging = l.percentile(p.HedgePercentile, min)
+	}
+	if !hedging {
+		now := t.clock().Now()
+		resp, err := t.roundTrip(req)
+		if err == nil {
+			l.record(t.clock().Now().Sub(now))
+		}
+		return resp, err
+	}
+
+	results := make(chan attemptResult, 2)
+	var cancels []context.CancelFunc
+	start := func(r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		cancels = append(cancels, cancel)
+		id := len(cancels) - 1
+		go func() {
+			now := t.clock().Now()
+			resp, err := t.roundTrip(r.WithContext(ctx))
+			if err == nil {
+				l.record(t.clock().Now().Sub(now))
+			}
+			results <- attemptResult{id: id, resp: resp, err: err}
+		}()
+	}
+
+	start(req)
+	pending := 1
+	timer := t.clock().NewTimer(after)
+	defer timer.Stop()
+	hedge := timer.C()
+
+	for {
+		select {
+		case <-hedge:
+			hedge = nil
+			if hedged, err := rewind(req.Context(), req); err == nil {
+				start(hedged)
+				pending++
+			}
+		case res := <-results:
+			pending--
+			if res.err != nil && pending > 0 {
+				cancels[res.id]()
+				continue
+			}
+			for i, cancel := range cancels {
+				if i != res.id {
+					cancel()
+				}
+			}
+			if pending > 0 {
+				go discard(results, pending)
+			}
+			if res.resp == nil {
+				cancels[res.id]()
+				return nil, res.err
+			}
+			res.resp.Body = &cancelBody{ReadCloser: res.resp.Body, cancel: cancels[res.id]}
+			return res.resp, nil
+		}
+	}
+}
+
+// discard closes the responses of the attempts that lost the race
+func discard(results chan attemptResult, pending int) {
+	for i := 0; i < pending; i++ {
+		if res := <-results; res.resp != nil {
+			_ = res.resp.Body.Close()
+		}
+	}
+}
+
+// cancelBody releases the context of the winning attempt once its body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
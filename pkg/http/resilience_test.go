@@ -0,0 +1,203 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	goErrors "errors"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/ratelimit"
+)
+
+func TestTransport_Retries(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, string(b))
+		n := len(bodies)
+		mu.Unlock()
+		switch n {
+		case 1:
+			w.WriteHeader(http.StatusBadGateway)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer srv.Close()
+
+	c := http.Client{Transport: &Transport{
+		DefaultPolicy: &Policy{MaxRetries: 3, InitialDelay: time.Millisecond},
+	}}
+
+	req, err := http.NewRequest(http.MethodPut, srv.URL, ioutil.NopCloser(bytes.NewBufferString("payload")))
+	require.NoError(t, err)
+	resp, err := c.Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+
+	// not idempotent, so not retried
+	bodies = nil
+	resp, err = c.Post(srv.URL, "text/plain", bytes.NewBufferString("payload"))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	require.Len(t, bodies, 1)
+}
+
+func TestTransport_RetryBudget(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := http.Client{Transport: &Transport{
+		DefaultPolicy: &Policy{MaxRetries: 5, InitialDelay: time.Millisecond, Budget: NewRetryBudget(0.1, 2)},
+	}}
+
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Equal(t, 3, calls, "the budget allows two retries")
+
+	resp, err = c.Get(srv.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, 4, calls, "the budget is spent")
+}
+
+func TestTransport_RetryAfterTooLong(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := http.Client{Transport: &Transport{
+		DefaultPolicy: &Policy{MaxRetries: 3, MaxRetryAfter: time.Second},
+	}}
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, 1, calls)
+}
+
+func TestTransport_DefaultMaxRetryAfter(t *testing.T) {
+	tr := &Transport{}
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+
+	resp.Header.Set("Retry-After", "3600")
+	_, ok := tr.retryDelay(&Policy{MaxRetries: 3}, 0, resp, nil)
+	require.False(t, ok, "an hour exceeds the default cap")
+
+	resp.Header.Set("Retry-After", "2")
+	d, ok := tr.retryDelay(&Policy{MaxRetries: 3}, 0, resp, nil)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Second, d)
+}
+
+func TestTransport_BackoffRand(t *testing.T) {
+	p := &Policy{InitialDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	a := &Transport{Rand: rand.New(rand.NewSource(1))}
+	b := &Transport{Rand: rand.New(rand.NewSource(1))}
+	for attempt := 0; attempt < 5; attempt++ {
+		d := a.backoff(p, attempt)
+		require.Equal(t, d, b.backoff(p, attempt))
+		require.True(t, d <= time.Second)
+	}
+}
+
+func TestTransport_DefaultInitialDelay(t *testing.T) {
+	tr := &Transport{Rand: rand.New(rand.NewSource(1))}
+	p := &Policy{MaxRetries: 3}
+	var total time.Duration
+	for attempt := 0; attempt < 3; attempt++ {
+		d := tr.backoff(p, attempt)
+		require.True(t, d <= defaultInitialDelay<<attempt, "delay %s out of range", d)
+		total += d
+	}
+	require.True(t, total > 0, "a policy without InitialDelay must not retry in a hot loop")
+}
+
+func TestTransport_Hedging(t *testing.T) {
+	var mu sync.Mutex
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		// the first request after the warm up hangs until the client gives up on it
+		if n == 3 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	c := http.Client{Transport: &Transport{
+		DefaultPolicy: &Policy{HedgePercentile: 0.9, HedgeMinSamples: 2},
+	}}
+
+	get := func() string {
+		resp, err := c.Get(srv.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+	require.Equal(t, "ok", get())
+	require.Equal(t, "ok", get())
+
+	now := time.Now()
+	require.Equal(t, "ok", get())
+	require.True(t, time.Since(now) < time.Second, "the hedged request did not answer")
+	mu.Lock()
+	require.Equal(t, 4, calls)
+	mu.Unlock()
+}
+
+func TestTransport_Limiter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c := http.Client{Transport: &Transport{Limiter: ratelimit.NewTokenBucket(1, 1)}}
+	resp, err := c.Get(srv.URL)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+	_, err = c.Do(req)
+	require.True(t, goErrors.Is(err, context.DeadlineExceeded))
+}
@@ -2,9 +2,14 @@ package identity
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	goErrors "errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/go-chi/jwtauth/v5"
@@ -16,19 +21,70 @@ import (
 type ValidatorOption func(validator *Validator)
 
 type ValidatorConfig struct {
-	ClientID      string `split_words:"true" required:"true"`
-	ConnectionURL string `split_words:"true" required:"true"`
+	ClientID      string `split_words:"true"`
+	ConnectionURL string `split_words:"true"`
 	Issuer        string `split_words:"true" required:"false"`
 
 	// Optional Skip Checks
 	SkipClientIDCheck bool `split_words:"true" default:"false"`
 	SkipExpiryCheck   bool `split_words:"true" default:"false"`
 	SkipIssuerCheck   bool `split_words:"true" default:"false"`
+
+	// Issuers are accepted in addition to the issuer at ConnectionURL, which can then be left empty
+	Issuers IssuerConfigs `split_words:"true"`
 }
 
-type Validator struct {
-	jwtAuth  *jwtauth.JWTAuth
+// IssuerConfig is an issuer accepted by a Validator
+type IssuerConfig struct {
+	// Issuer is the iss claim of the tokens of the issuer
+	Issuer string `json:"issuer"`
+	// ConnectionURL is where the provider is discovered, defaults to Issuer. Set it when the provider is reached
+	// through an internal url, e.g. inside the same cluster in k8s.
+	ConnectionURL string `json:"connection_url,omitempty"`
+	// Audiences holds the client IDs tokens are accepted for, the aud claim has to contain one of them
+	Audiences []string `json:"audiences,omitempty"`
+
+	SkipClientIDCheck bool `json:"skip_client_id_check,omitempty"`
+	SkipExpiryCheck   bool `json:"skip_expiry_check,omitempty"`
+	// SkipIssuerCheck accepts tokens of any issuer no other IssuerConfig matches
+	SkipIssuerCheck bool `json:"skip_issuer_check,omitempty"`
+}
+
+// IssuerConfigs holds issuers as JSON, e.g.
+//
+//	[{"issuer": "https://keycloak.example.com/auth/realms/tenants", "audiences": ["api", "portal"]}]
+type IssuerConfigs []IssuerConfig
+
+// Decode implements envconfig.Decoder
+func (c *IssuerConfigs) Decode(value string) error {
+	var issuers []IssuerConfig
+	if err := json.Unmarshal([]byte(value), &issuers); err != nil {
+		return errors.Wrap(err, "invalid issuers")
+	}
+	*c = issuers
+	return nil
+}
+
+// discoveryRetryInterval is how long a Validator waits before discovering an unreachable provider again
+var discoveryRetryInterval = 10 * time.Second
+
+// discoveryTimeout bounds the discovery of a provider
+var discoveryTimeout = 10 * time.Second
+
+type issuer struct {
+	cfg IssuerConfig
+
+	mu       sync.Mutex
 	verifier *oidc.IDTokenVerifier
+	// discovering is closed once the discovery in progress finished, nil when there is none
+	discovering chan struct{}
+	lastAttempt time.Time
+	lastErr     error
+}
+
+type Validator struct {
+	jwtAuth *jwtauth.JWTAuth
+	issuers []*issuer
 }
 
 func JWTClientValidatorOpt(signingKey string) ValidatorOption {
@@ -37,33 +93,216 @@ func JWTClientValidatorOpt(signingKey string) ValidatorOption {
 	}
 }
 
+// NewValidator creates a Validator for the issuer at ConnectionURL and the Issuers of cfg. The issuer at
+// ConnectionURL has to be reachable, the Issuers are discovered concurrently and, if they are not reachable, when
+// they are first needed.
 func NewValidator(cfg ValidatorConfig, opts ...ValidatorOption) (*Validator, error) {
-	ctx := context.Background()
-	if cfg.Issuer != "" {
+	var validator Validator
+
+	if cfg.ConnectionURL != "" {
+		i := issuer{
+			cfg: IssuerConfig{
+				Issuer:            cfg.Issuer,
+				ConnectionURL:     cfg.ConnectionURL,
+				SkipClientIDCheck: cfg.SkipClientIDCheck,
+				SkipExpiryCheck:   cfg.SkipExpiryCheck,
+				SkipIssuerCheck:   cfg.SkipIssuerCheck,
+			},
+		}
+		if i.cfg.Issuer == "" {
+			i.cfg.Issuer = cfg.ConnectionURL
+		}
+		if cfg.ClientID != "" {
+			i.cfg.Audiences = []string{cfg.ClientID}
+		}
+		if err := i.check(); err != nil {
+			return nil, err
+		}
+		if _, err := i.discoverWithTimeout(context.Background()); err != nil {
+			return nil, err
+		}
+		validator.issuers = append(validator.issuers, &i)
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range cfg.Issuers {
+		i := &issuer{cfg: c}
+		if err := i.check(); err != nil {
+			return nil, err
+		}
+		validator.issuers = append(validator.issuers, i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// unreachable providers are discovered again once their tokens come in
+			_, _ = i.discoverWithTimeout(context.Background())
+		}()
+	}
+	wg.Wait()
+
+	if len(validator.issuers) == 0 {
+		return nil, errors.Wrapf("identity: either a ConnectionURL or Issuers are required")
+	}
+
+	for _, opt := range opts {
+		opt(&validator)
+	}
+
+	return &validator, nil
+}
+
+func (i *issuer) check() error {
+	if i.cfg.Issuer == "" {
+		return errors.Wrapf("identity: issuer without an issuer url")
+	}
+	if len(i.cfg.Audiences) == 0 && !i.cfg.SkipClientIDCheck {
+		return errors.Wrapf("identity: issuer %s needs audiences or SkipClientIDCheck", i.cfg.Issuer)
+	}
+	return nil
+}
+
+func (i *issuer) discoverWithTimeout(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	ctx, cancel := context.WithTimeout(ctx, discoveryTimeout)
+	defer cancel()
+	return i.discover(ctx)
+}
+
+// discover returns the verifier of the issuer, discovering its provider unless that failed less than
+// discoveryRetryInterval ago. Requests wait for a discovery that is in progress until ctx is done.
+func (i *issuer) discover(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	i.mu.Lock()
+	for i.discovering != nil {
+		discovering := i.discovering
+		i.mu.Unlock()
+		select {
+		case <-discovering:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		i.mu.Lock()
+	}
+	if i.verifier != nil {
+		defer i.mu.Unlock()
+		return i.verifier, nil
+	}
+	if !i.lastAttempt.IsZero() && time.Since(i.lastAttempt) < discoveryRetryInterval {
+		defer i.mu.Unlock()
+		return nil, i.lastErr
+	}
+	discovering := make(chan struct{})
+	i.discovering = discovering
+	i.lastAttempt = time.Now()
+	i.mu.Unlock()
+
+	verifier, err := i.newVerifier(ctx)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.discovering = nil
+	close(discovering)
+	if err != nil {
+		i.lastErr = err
+		return nil, err
+	}
+	i.verifier = verifier
+	i.lastErr = nil
+	return verifier, nil
+}
+
+func (i *issuer) newVerifier(ctx context.Context) (*oidc.IDTokenVerifier, error) {
+	url := i.cfg.ConnectionURL
+	if url == "" {
+		url = i.cfg.Issuer
+	} else if url != i.cfg.Issuer {
 		// this allows you to manually set the issuer when the connection url is
 		// an internal url (needed for hitting the endpoint inside the same cluster in k8s
-		ctx = oidc.InsecureIssuerURLContext(ctx, cfg.Issuer)
+		ctx = oidc.InsecureIssuerURLContext(ctx, i.cfg.Issuer)
 	}
 
-	provider, err := oidc.NewProvider(ctx, cfg.ConnectionURL)
+	provider, err := oidc.NewProvider(ctx, url)
+	if err != nil {
+		return nil, errors.Wrap(err, "identity: failed to discover %s", i.cfg.Issuer)
+	}
+
+	return provider.Verifier(&oidc.Config{
+		ClientID:          i.clientID(),
+		SkipClientIDCheck: i.cfg.SkipClientIDCheck || len(i.cfg.Audiences) > 1,
+		SkipExpiryCheck:   i.cfg.SkipExpiryCheck,
+		SkipIssuerCheck:   i.cfg.SkipIssuerCheck,
+	}), nil
+}
+
+func (i *issuer) clientID() string {
+	if len(i.cfg.Audiences) == 1 {
+		return i.cfg.Audiences[0]
+	}
+	return ""
+}
+
+func (i *issuer) verify(ctx context.Context, token string) (*oidc.IDToken, error) {
+	verifier, err := i.discoverWithTimeout(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	validator := Validator{
-		verifier: provider.Verifier(&oidc.Config{
-			ClientID:          cfg.ClientID,
-			SkipClientIDCheck: cfg.SkipClientIDCheck,
-			SkipExpiryCheck:   cfg.SkipExpiryCheck,
-			SkipIssuerCheck:   cfg.SkipIssuerCheck,
-		}),
+	idToken, err := verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, opt := range opts {
-		opt(&validator)
+	// the verifier only checks a single client id
+	if !i.cfg.SkipClientIDCheck && len(i.cfg.Audiences) > 1 {
+		for _, aud := range idToken.Audience {
+			for _, expected := range i.cfg.Audiences {
+				if aud == expected {
+					return idToken, nil
+				}
+			}
+		}
+		return nil, fmt.Errorf("identity: expected audience in %q got %q", i.cfg.Audiences, idToken.Audience)
 	}
+	return idToken, nil
+}
 
-	return &validator, nil
+// issuersFor returns the issuers that may have issued token, picked by its unverified iss claim
+func (svc *Validator) issuersFor(token string) []*issuer {
+	iss := unverifiedIssuer(token)
+
+	var matches []*issuer
+	for _, i := range svc.issuers {
+		if iss != "" && i.cfg.Issuer == iss {
+			matches = append(matches, i)
+		}
+	}
+	if len(matches) > 0 {
+		return matches
+	}
+
+	for _, i := range svc.issuers {
+		if i.cfg.SkipIssuerCheck {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// unverifiedIssuer returns the iss claim of token without verifying it
+func unverifiedIssuer(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+	return claims.Issuer
 }
 
 // Validate verifies the incoming token request
@@ -75,14 +314,17 @@ func (svc *Validator) Validate(r *http.Request) (jwt.MapClaims, error) {
 		return nil, errors.ErrEmptyToken
 	}
 
-	// Attempt to verify the token again OIDC provider (Keycloak via Okta auth) first
+	// Attempt to verify the token against the OIDC provider of its issuer (Keycloak via Okta auth) first
 	// If it's a valid token (no error) return immediately
-	keyCloakToken, verr := svc.verifier.Verify(ctx, token)
-	if verr != nil {
-		if goErrors.Is(verr, jwtauth.ErrExpired) {
-			return nil, errors.ErrExpired
+	for _, i := range svc.issuersFor(token) {
+		keyCloakToken, verr := i.verify(ctx, token)
+		if verr != nil {
+			if goErrors.Is(verr, jwtauth.ErrExpired) {
+				return nil, errors.ErrExpired
+			}
+			continue
 		}
-	} else {
+
 		var idTokenClaims = new(jwt.MapClaims)
 		if err := keyCloakToken.Claims(&idTokenClaims); err != nil {
 			return nil, errors.ErrMapTokenClaims
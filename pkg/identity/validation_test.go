@@ -0,0 +1,185 @@
+package identity
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	goErrors "errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+type provider struct {
+	*httptest.Server
+	key  *rsa.PrivateKey
+	down int32
+}
+
+func newProvider(t *testing.T) *provider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p := provider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&p.down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "k1",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+			}},
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	return &p
+}
+
+func (p *provider) token(t *testing.T, aud string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss": p.URL,
+		"sub": "1234",
+		"aud": aud,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	})
+	token.Header["kid"] = "k1"
+	s, err := token.SignedString(p.key)
+	require.NoError(t, err)
+	return s
+}
+
+func TestValidator_Issuers(t *testing.T) {
+	discoveryRetryInterval = 0
+	defer func() { discoveryRetryInterval = 10 * time.Second }()
+
+	tenants := newProvider(t)
+	defer tenants.Close()
+	staff := newProvider(t)
+	defer staff.Close()
+	unknown := newProvider(t)
+	defer unknown.Close()
+
+	// unreachable at startup
+	atomic.StoreInt32(&staff.down, 1)
+
+	v, err := NewValidator(ValidatorConfig{
+		ClientID:      "api",
+		ConnectionURL: tenants.URL,
+		Issuers: IssuerConfigs{
+			{Issuer: staff.URL, Audiences: []string{"api", "portal"}},
+		},
+	})
+	require.NoError(t, err)
+
+	validate := func(token string) (jwt.MapClaims, error) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return v.Validate(req)
+	}
+
+	claims, err := validate(tenants.token(t, "api"))
+	require.NoError(t, err)
+	require.Equal(t, tenants.URL, claims["iss"])
+
+	_, err = validate(tenants.token(t, "portal"))
+	require.Equal(t, errors.ErrUnauthorized, err)
+
+	_, err = validate(staff.token(t, "portal"))
+	require.Equal(t, errors.ErrUnauthorized, err)
+
+	atomic.StoreInt32(&staff.down, 0)
+	claims, err = validate(staff.token(t, "portal"))
+	require.NoError(t, err)
+	require.Equal(t, staff.URL, claims["iss"])
+
+	_, err = validate(staff.token(t, "other"))
+	require.Equal(t, errors.ErrUnauthorized, err)
+
+	_, err = validate(unknown.token(t, "api"))
+	require.Equal(t, errors.ErrUnauthorized, err)
+
+	_, err = validate("")
+	require.Equal(t, errors.ErrEmptyToken, err)
+}
+
+func TestNewValidator(t *testing.T) {
+	_, err := NewValidator(ValidatorConfig{})
+	require.Error(t, err)
+
+	_, err = NewValidator(ValidatorConfig{Issuers: IssuerConfigs{{Issuer: "https://idp.example.com"}}})
+	require.Error(t, err, "audiences are required")
+
+	var issuers IssuerConfigs
+	require.NoError(t, issuers.Decode(`[{"issuer": "http://127.0.0.1:1", "skip_client_id_check": true}]`))
+	_, err = NewValidator(ValidatorConfig{Issuers: issuers})
+	require.NoError(t, err, "unreachable issuers are discovered later")
+}
+
+func TestNewValidator_HangingIssuer(t *testing.T) {
+	discoveryTimeout = 200 * time.Millisecond
+	defer func() { discoveryTimeout = 10 * time.Second }()
+
+	release := make(chan struct{})
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer hanging.Close()
+	defer close(release)
+
+	// the issuers are discovered concurrently, startup takes a single discovery timeout
+	now := time.Now()
+	v, err := NewValidator(ValidatorConfig{Issuers: IssuerConfigs{
+		{Issuer: hanging.URL + "/a", SkipClientIDCheck: true},
+		{Issuer: hanging.URL + "/b", SkipClientIDCheck: true},
+		{Issuer: hanging.URL + "/c", SkipClientIDCheck: true},
+	}})
+	require.NoError(t, err)
+	require.True(t, time.Since(now) < 2*discoveryTimeout, "startup discovery took %s", time.Since(now))
+
+	// validations wait for a discovery in progress, bounded by their context
+	i := v.issuers[0]
+	discovering := make(chan struct{})
+	i.mu.Lock()
+	i.discovering = discovering
+	i.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = i.discover(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		i.mu.Lock()
+		i.discovering = nil
+		i.lastErr = goErrors.New("unreachable")
+		close(discovering)
+		i.mu.Unlock()
+	}()
+	_, err = i.discover(context.Background())
+	require.EqualError(t, err, "unreachable")
+}
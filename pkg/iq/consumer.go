@@ -0,0 +1,408 @@
+package iq
+
+import (
+	"context"
+	gjson "encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/errors"
+	"github.com/unanet/go/v2/pkg/log"
+	"github.com/unanet/go/v2/pkg/metrics"
+	"github.com/unanet/go/v2/pkg/retry"
+)
+
+// consumer receives messages from a single queue and runs them through a Handler, deleting every message its
+// Handler succeeded on. It holds everything InstanceQ and WorkQ have in common.
+type consumer struct {
+	log            *zap.Logger
+	kind           string
+	name           string
+	ctx            context.Context
+	cancel         context.CancelFunc
+	done           chan bool
+	transport      Transport
+	c              *Config
+	qurl           string
+	qarn           string
+	dlqurl         string
+	dlqarn         string
+	subscriptions  []string
+	verifier       *SignatureVerifier
+	filterPolicies FilterPolicies
+	middlewares    []Middleware
+	health         health
+
+	hctx     context.Context
+	hcancel  context.CancelFunc
+	slots    chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	inflight map[string]*inflight
+}
+
+func newConsumer(kind string, name string, t Transport, c *Config, opts []Option) *consumer {
+	q := consumer{
+		kind:      kind,
+		name:      name,
+		log:       log.Logger.With(zap.String("worker", name)),
+		c:         c,
+		transport: t,
+		done:      make(chan bool),
+	}
+
+	q.health.threshold = int(c.FailureThreshold)
+
+	if c.VerifySignatures {
+		q.verifier = NewSignatureVerifier()
+	}
+
+	q.filterPolicies = make(FilterPolicies)
+	for k, v := range c.FilterPolicies {
+		q.filterPolicies[k] = v
+	}
+
+	for _, opt := range opts {
+		opt(&q)
+	}
+
+	return &q
+}
+
+// createQueue creates qname, and its dead-letter queue when enabled, then subscribes it to the configured topics
+func (q *consumer) createQueue(ctx context.Context, qname string, tags map[string]string) error {
+	attributes := map[string]string{
+		"DelaySeconds":           fmt.Sprint(q.c.DeliveryDelay),
+		"VisibilityTimeout":      fmt.Sprint(q.c.VisibilityTimeout),
+		"MessageRetentionPeriod": fmt.Sprint(q.c.MessageRetentionPeriod),
+	}
+
+	if q.c.DeadLetterQueue {
+		dlq, err := q.createDLQ(ctx, qname, tags)
+		if err != nil {
+			return err
+		}
+		attributes["RedrivePolicy"] = dlq
+	}
+
+	result, err := q.transport.CreateQueue(ctx, qname, attributes, tags)
+	if err != nil {
+		return err
+	}
+
+	q.qurl = result.URL
+	q.qarn = result.ARN
+
+	return q.subscribe(ctx)
+}
+
+// subscribe subscribes the queue to the configured topics, reconciles the filter policies of the subscriptions and
+// allows the topics to send to the queue. Subscribing is idempotent, so it also runs for queues that already exist.
+func (q *consumer) subscribe(ctx context.Context) error {
+	qarn := q.qarn
+
+	var subscriptions []string
+	var policies []interface{}
+
+	log.Logger.Debug("subscribe Q ARns", zap.String("qarn", qarn), zap.Strings("topic_arns", q.c.TopicArns))
+
+	for _, x := range q.c.TopicArns {
+		log.Logger.Debug("subscription to topic ARns", zap.String("arn", x))
+
+		r, err := q.transport.Subscribe(ctx, x, qarn, q.subscriptionAttributes())
+		if err != nil {
+			log.Logger.Error("failed to subscribe to topic", zap.String("topic", x), zap.String("iq", qarn), zap.Error(err))
+			return err
+		} else {
+			subscriptions = append(subscriptions, r)
+			policies = append(policies, getSqsPolicy(qarn, x))
+		}
+
+		if err := q.reconcileFilterPolicy(ctx, r, x); err != nil {
+			log.Logger.Error("failed to set subscription filter policy", zap.String("topic", x), zap.String("iq", qarn), zap.Error(err))
+			return err
+		}
+	}
+
+	q.subscriptions = subscriptions
+	if len(policies) == 0 {
+		return nil
+	}
+
+	log.Logger.Debug("queue policies", zap.Any("policies", policies))
+
+	b, err := gjson.Marshal(map[string]interface{}{
+		"Statement": policies,
+	})
+	if err != nil {
+		log.Logger.Error("failed to marshal sqs policies", zap.Error(err))
+	}
+
+	policy := string(b)
+
+	err = q.transport.SetQueueAttributes(ctx, q.qurl, map[string]string{
+		"Policy": policy,
+	})
+	if err != nil {
+		log.Logger.Error("failed to set sqs policy", zap.Error(err), zap.String("policy", policy))
+	}
+
+	return nil
+}
+
+func (q *consumer) subscriptionAttributes() map[string]string {
+	attributes := make(map[string]string)
+	if q.c.RawMessageDelivery {
+		attributes["RawMessageDelivery"] = "true"
+	}
+	return attributes
+}
+
+// start runs the receive loop in the background until stop is called
+func (q *consumer) start(h Handler) {
+	h = q.chain(h)
+
+	cctx, ccancel := context.WithCancel(context.Background())
+	q.ctx = cctx
+	q.cancel = ccancel
+	q.hctx, q.hcancel = context.WithCancel(context.Background())
+	q.slots = make(chan struct{}, q.maxInFlight())
+	q.inflight = make(map[string]*inflight)
+
+	q.health.started()
+
+	go func() {
+		q.log.Info(q.kind + " worker started")
+		for {
+			// block until at least one handler slot is free, then grab as many more as are available
+			select {
+			case <-q.ctx.Done():
+				q.log.Info(q.kind + " worker stopped")
+				q.health.stopped()
+				close(q.done)
+				return
+			case q.slots <- struct{}{}:
+			}
+			free := q.acquireSlots(1)
+
+			var m []*mContext
+			// failed receives are retried with an increasing, jittered delay until one succeeds or the worker is
+			// stopped, the delay is capped so the worker polls again soon after the queue recovered. Only the last
+			// error is kept since an outage can last for any number of attempts.
+			_ = retry.Do(q.ctx, func() error {
+				var err error
+				m, err = q.receive(q.ctx, free)
+				if err != nil {
+					failures := q.health.failure(err)
+					q.log.Error("error receiving message from queue", zap.Error(err), zap.Int("consecutive_failures", failures))
+					return err
+				}
+				q.health.success()
+				return nil
+			}, retry.WithMaxDelay(q.receiveMaxBackoff()), retry.WithJitter(retry.FullJitter), retry.WithHistory(1))
+			q.releaseSlots(free - len(m))
+			q.run(h, m)
+		}
+	}()
+}
+
+func (q *consumer) maxInFlight() int {
+	if q.c.MaxInFlight > 0 {
+		return int(q.c.MaxInFlight)
+	}
+	return int(q.c.MaxNumberOfMessages)
+}
+
+// acquireSlots takes free handler slots without blocking, up to MaxNumberOfMessages in total
+func (q *consumer) acquireSlots(acquired int) int {
+	for int64(acquired) < q.c.MaxNumberOfMessages {
+		select {
+		case q.slots <- struct{}{}:
+			acquired++
+		default:
+			return acquired
+		}
+	}
+	return acquired
+}
+
+func (q *consumer) releaseSlots(n int) {
+	for i := 0; i < n; i++ {
+		<-q.slots
+	}
+}
+
+// stop stops receiving messages and waits for in-flight handlers to finish until ctx is done. Messages still being
+// handled at that point are released back to the queue by resetting their visibility timeout and their handler
+// context is cancelled. stop returns ctx.Err() if the handlers did not drain in time.
+func (q *consumer) stop(ctx context.Context) error {
+	q.cancel()
+	<-q.done
+
+	drained := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+		q.log.Info(q.kind + " worker drained")
+	case <-ctx.Done():
+		err = ctx.Err()
+		q.releaseInFlight()
+	}
+
+	q.hcancel()
+	return err
+}
+
+// receiveMaxBackoff is the longest delay between retries of failed receives, Config.ReceiveMaxBackoff or 30s
+func (q *consumer) receiveMaxBackoff() time.Duration {
+	if q.c.ReceiveMaxBackoff <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(q.c.ReceiveMaxBackoff) * time.Second
+}
+
+// handlerTimeout is the default time a Handler gets for a message, see Timeout
+func (q *consumer) handlerTimeout() time.Duration {
+	return time.Duration(q.c.HandlerTimeout) * time.Second
+}
+
+// releaseInFlight makes every message that is still being handled visible again so another receive can pick it up
+func (q *consumer) releaseInFlight() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, f := range q.inflight {
+		f.released = true
+		f.cancel()
+		if err := q.transport.ChangeMessageVisibility(context.Background(), q.qurl, f.m.ReceiptHandle, 0); err != nil {
+			q.logWith(f.m.ctx).Error("error releasing message", zap.Error(err), zap.String("id", f.m.ID))
+			continue
+		}
+		q.logWith(f.m.ctx).Info("notification message released", zap.String("id", f.m.ID))
+	}
+}
+
+// run dispatches each message to its own handler goroutine, every message holds one of the acquired slots until
+// its handler returns
+func (q *consumer) run(h Handler, mCtx []*mContext) {
+	for _, mc := range mCtx {
+		// the handler timeout is applied inside the middleware chain, where Timeout can replace it
+		ctx, cancel := context.WithCancel(mc.ctx)
+		f := &inflight{q: q, m: mc, cancel: cancel}
+		ctx = context.WithValue(ctx, inflightKey, f)
+		ctx = context.WithValue(ctx, handlerTimeoutKey, q.handlerTimeout())
+
+		q.mu.Lock()
+		q.inflight[mc.ReceiptHandle] = f
+		q.mu.Unlock()
+
+		q.wg.Add(1)
+		go func(ctx context.Context, f *inflight) {
+			defer q.wg.Done()
+			defer func() { <-q.slots }()
+			defer f.cancel()
+
+			if q.c.VisibilityExtension > 0 {
+				go f.heartbeat(ctx, q.c.VisibilityExtension)
+			}
+
+			topicArn := f.m.Notification.TopicArn
+			metrics.StatIQInFlightGauge.WithLabelValues(q.c.Prefix).Inc()
+			now := time.Now()
+			vctx, vcancel := context.WithTimeout(ctx, q.handlerTimeout())
+			err := q.verify(vctx, &f.m.M)
+			vcancel()
+			if err == nil {
+				err = h.HandleMessage(ctx, &f.m.M)
+			}
+			metrics.StatIQHandlerDurationHistogram.WithLabelValues(topicArn, q.c.Prefix).Observe(time.Since(now).Seconds())
+			metrics.StatIQInFlightGauge.WithLabelValues(q.c.Prefix).Dec()
+
+			q.mu.Lock()
+			delete(q.inflight, f.m.ReceiptHandle)
+			released := f.released
+			q.mu.Unlock()
+
+			if err != nil {
+				metrics.StatIQMessagesFailedCount.WithLabelValues(topicArn, q.c.Prefix).Inc()
+				q.logWith(f.m.ctx).Error("error handling message", zap.Error(err), zap.String("id", f.m.ID))
+				return
+			}
+
+			metrics.StatIQMessagesHandledCount.WithLabelValues(topicArn, q.c.Prefix).Inc()
+			if !released {
+				err = q.deleteMessage(f.m.ctx, &f.m.M)
+				if err != nil {
+					q.logWith(f.m.ctx).Error("error deleting message", zap.Error(err), zap.String("id", f.m.ID))
+				}
+			}
+		}(ctx, f)
+	}
+}
+
+func (q *consumer) verify(ctx context.Context, m *M) error {
+	if q.verifier == nil || m.Envelope != EnvelopeSNS {
+		return nil
+	}
+	return q.verifier.Verify(ctx, &m.Notification)
+}
+
+func (q *consumer) logWith(ctx context.Context) *zap.Logger {
+	return q.log.With(zap.String("req_id", log.GetReqID(ctx)))
+}
+
+func (q *consumer) receive(ctx context.Context, max int) ([]*mContext, error) {
+	now := time.Now()
+	result, err := q.transport.ReceiveMessages(ctx, q.qurl, ReceiveOptions{
+		MaxNumberOfMessages: int64(max),
+		VisibilityTimeout:   q.c.VisibilityTimeout,
+		WaitTimeSeconds:     q.c.WaitTimeSecond,
+	})
+	metrics.StatIQReceiveDurationHistogram.WithLabelValues(q.c.Prefix).Observe(time.Since(now).Seconds())
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "RequestCanceled") || ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err)
+	}
+
+	var returnMs []*mContext
+	for _, x := range result {
+		// handler contexts outlive the receive context so in-flight messages can drain after Stop
+		mc := newMContext(q.hctx, x, q.c.Envelope)
+		returnMs = append(returnMs, mc)
+		q.logWith(mc.ctx).Info("notification message received",
+			zap.Any("id", mc.ID),
+		)
+		metrics.StatIQMessagesReceivedCount.WithLabelValues(mc.Notification.TopicArn, q.c.Prefix).Inc()
+		if sentAt := mc.SentAt(); !sentAt.IsZero() {
+			metrics.StatIQMessageAgeHistogram.WithLabelValues(mc.Notification.TopicArn, q.c.Prefix).
+				Observe(time.Since(sentAt).Seconds())
+		}
+	}
+
+	return returnMs, nil
+}
+
+func (q *consumer) deleteMessage(ctx context.Context, m *M) error {
+	now := time.Now()
+	err := q.transport.DeleteMessage(ctx, q.qurl, m.ReceiptHandle)
+	if err != nil {
+		return errors.Wrap(err)
+	}
+	metrics.StatIQMessagesDeletedCount.WithLabelValues(m.Notification.TopicArn, q.c.Prefix).Inc()
+	q.logWith(ctx).Info("notification message deleted",
+		zap.Float64("elapsed_ms", float64(time.Since(now).Nanoseconds())/1000000.0),
+		zap.Any("id", m.ID),
+	)
+	return nil
+}
@@ -0,0 +1,62 @@
+package iq
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+// SQLDedupStore is a DedupStore backed by a Postgres table, which lets every instance of a service share the keys
+// of handled messages. It only supports Postgres, its queries use $n placeholders and ON CONFLICT. The table needs a
+// unique key column and an expiry column:
+//
+//	CREATE TABLE iq_dedup (
+//	    key        TEXT PRIMARY KEY,
+//	    expires_at TIMESTAMPTZ NOT NULL
+//	);
+type SQLDedupStore struct {
+	db    *sql.DB
+	table string
+}
+
+func NewSQLDedupStore(db *sql.DB, table string) *SQLDedupStore {
+	return &SQLDedupStore{
+		db:    db,
+		table: table,
+	}
+}
+
+func (s *SQLDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT 1 FROM %s WHERE key = $1 AND expires_at > $2", s.table),
+		key, time.Now().UTC()).Scan(&n)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+	return true, nil
+}
+
+func (s *SQLDedupStore) Mark(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("INSERT INTO %s (key, expires_at) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET expires_at = EXCLUDED.expires_at", s.table),
+		key, time.Now().UTC().Add(ttl))
+	return errors.Wrap(err)
+}
+
+// Purge deletes expired keys and returns how many were deleted, it is meant to run periodically
+func (s *SQLDedupStore) Purge(ctx context.Context) (int64, error) {
+	result, err := s.db.ExecContext(ctx,
+		fmt.Sprintf("DELETE FROM %s WHERE expires_at <= $1", s.table), time.Now().UTC())
+	if err != nil {
+		return 0, errors.Wrap(err)
+	}
+	n, err := result.RowsAffected()
+	return n, errors.Wrap(err)
+}
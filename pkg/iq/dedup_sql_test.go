@@ -0,0 +1,47 @@
+package iq
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLDedupStore(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() { _ = db.Close() }()
+	s := NewSQLDedupStore(db, "iq_dedup")
+
+	seen := regexp.QuoteMeta("SELECT 1 FROM iq_dedup WHERE key = $1 AND expires_at > $2")
+	mock.ExpectQuery(seen).WithArgs("a", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"?column?"}))
+	ok, err := s.Seen(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO iq_dedup (key, expires_at) VALUES ($1, $2) ON CONFLICT (key) DO UPDATE SET expires_at = EXCLUDED.expires_at")).
+		WithArgs("a", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, s.Mark(ctx, "a", time.Minute))
+
+	mock.ExpectQuery(seen).WithArgs("a", sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	ok, err = s.Seen(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	mock.ExpectQuery(seen).WithArgs("b", sqlmock.AnyArg()).WillReturnError(sql.ErrConnDone)
+	_, err = s.Seen(ctx, "b")
+	require.Error(t, err)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM iq_dedup WHERE expires_at <= $1")).
+		WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 3))
+	n, err := s.Purge(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), n)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,179 @@
+package iq
+
+import (
+	"context"
+	gjson "encoding/json"
+	goErrors "errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+// RedrivePolicy is the JSON document SQS expects in the RedrivePolicy queue attribute
+type RedrivePolicy struct {
+	DeadLetterTargetArn string `json:"deadLetterTargetArn"`
+	MaxReceiveCount     string `json:"maxReceiveCount"`
+}
+
+// createDLQ creates the dead-letter queue for qname and returns the redrive policy to attach to qname
+func (q *consumer) createDLQ(ctx context.Context, qname string, tags map[string]string) (string, error) {
+	dlq, err := q.transport.CreateQueue(ctx, fmt.Sprintf("%s_dlq", qname),
+		map[string]string{
+			"MessageRetentionPeriod": fmt.Sprint(q.c.DeadLetterRetentionPeriod),
+		}, tags)
+	if err != nil {
+		return "", err
+	}
+
+	q.dlqurl = dlq.URL
+	q.dlqarn = dlq.ARN
+
+	return q.redrivePolicy()
+}
+
+func (q *consumer) redrivePolicy() (string, error) {
+	b, err := gjson.Marshal(RedrivePolicy{
+		DeadLetterTargetArn: q.dlqarn,
+		MaxReceiveCount:     fmt.Sprint(q.c.MaxReceiveCount),
+	})
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+	return string(b), nil
+}
+
+// attachDLQ looks up the dead-letter queue of the existing queue qname, creating it when it is missing, and attaches
+// it to qname unless qname already has a redrive policy
+func (q *consumer) attachDLQ(ctx context.Context, qname string, tags map[string]string) error {
+	var redrive string
+	dlq, err := q.transport.GetQueue(ctx, fmt.Sprintf("%s_dlq", qname))
+	switch {
+	case goErrors.Is(err, ErrQueueDoesNotExist):
+		if redrive, err = q.createDLQ(ctx, qname, tags); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		q.dlqurl = dlq.URL
+		q.dlqarn = dlq.ARN
+	}
+
+	attributes, err := q.transport.GetQueueAttributes(ctx, q.qurl)
+	if err != nil {
+		return err
+	}
+	if attributes["RedrivePolicy"] != "" {
+		return nil
+	}
+	if redrive == "" {
+		if redrive, err = q.redrivePolicy(); err != nil {
+			return err
+		}
+	}
+	q.log.Info("attaching dead letter queue", zap.String("queue", qname), zap.String("dlq", q.dlqarn))
+	return q.transport.SetQueueAttributes(ctx, q.qurl, map[string]string{
+		"RedrivePolicy": redrive,
+	})
+}
+
+func (q *consumer) requireDLQ() error {
+	if q.dlqurl == "" {
+		return errors.Wrapf("dead letter queue is not enabled for %s", q.name)
+	}
+	return nil
+}
+
+// deadLetterWaitTime is how long DeadLetters long polls, long polling queries every SQS server so an empty result
+// means the dead-letter queue has no more visible messages
+const deadLetterWaitTime = 1
+
+// DeadLetters receives up to max messages from the dead-letter queue so they can be inspected. The messages stay
+// in the dead-letter queue and become visible again after visibilityTimeout seconds unless they are replayed or
+// deleted using the returned M.
+func (q *consumer) DeadLetters(ctx context.Context, max int64, visibilityTimeout int64) ([]*M, error) {
+	if err := q.requireDLQ(); err != nil {
+		return nil, err
+	}
+
+	var ms []*M
+	seen := make(map[string]int)
+	for int64(len(ms)) < max {
+		n := max - int64(len(ms))
+		if n > 10 {
+			n = 10
+		}
+		result, err := q.transport.ReceiveMessages(ctx, q.dlqurl, ReceiveOptions{
+			MaxNumberOfMessages: n,
+			VisibilityTimeout:   visibilityTimeout,
+			WaitTimeSeconds:     deadLetterWaitTime,
+		})
+		if err != nil {
+			return ms, errors.Wrap(err)
+		}
+		var added int
+		for _, x := range result {
+			// with a short visibility timeout the same message can be received again on the next poll, only the
+			// latest receipt handle is valid
+			if i, ok := seen[x.ID]; ok {
+				ms[i] = &newMContext(ctx, x, q.c.Envelope).M
+				continue
+			}
+			seen[x.ID] = len(ms)
+			ms = append(ms, &newMContext(ctx, x, q.c.Envelope).M)
+			added++
+		}
+		if added == 0 {
+			break
+		}
+	}
+
+	return ms, nil
+}
+
+// ReplayDeadLetter sends a message obtained from DeadLetters back to the instance queue and removes it from the
+// dead-letter queue
+func (q *consumer) ReplayDeadLetter(ctx context.Context, m *M) error {
+	if err := q.requireDLQ(); err != nil {
+		return err
+	}
+	if m.message == nil {
+		return errors.Wrapf("message %s was not received from a dead letter queue", m.ID)
+	}
+
+	if _, err := q.transport.SendMessage(ctx, q.qurl, m.message.Body, m.message.MessageAttributes); err != nil {
+		return errors.Wrap(err)
+	}
+
+	q.logWith(ctx).Info("dead letter message replayed", zap.String("id", m.ID))
+
+	return q.DeleteDeadLetter(ctx, m)
+}
+
+// DeleteDeadLetter permanently removes a message obtained from DeadLetters
+func (q *consumer) DeleteDeadLetter(ctx context.Context, m *M) error {
+	if err := q.requireDLQ(); err != nil {
+		return err
+	}
+
+	return errors.Wrap(q.transport.DeleteMessage(ctx, q.dlqurl, m.ReceiptHandle))
+}
+
+// ReplayDeadLetters moves up to max messages from the dead-letter queue back to the instance queue and returns the
+// number of messages replayed
+func (q *consumer) ReplayDeadLetters(ctx context.Context, max int64) (int, error) {
+	ms, err := q.DeadLetters(ctx, max, q.c.VisibilityTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range ms {
+		if err := q.ReplayDeadLetter(ctx, m); err != nil {
+			return i, err
+		}
+	}
+
+	return len(ms), nil
+}
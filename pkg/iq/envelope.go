@@ -0,0 +1,135 @@
+package iq
+
+import (
+	"bytes"
+	"context"
+	gjson "encoding/json"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/log"
+)
+
+const (
+	EnvelopeAuto        = "auto"
+	EnvelopeSNS         = "sns"
+	EnvelopeRaw         = "raw"
+	EnvelopeEventBridge = "eventbridge"
+)
+
+// EventBridgeEvent is the envelope of an event delivered to SQS by an EventBridge rule
+type EventBridgeEvent struct {
+	Version    string           `json:"version"`
+	ID         string           `json:"id"`
+	DetailType string           `json:"detail-type"`
+	Source     string           `json:"source"`
+	Account    string           `json:"account"`
+	Time       time.Time        `json:"time"`
+	Region     string           `json:"region"`
+	Resources  []string         `json:"resources"`
+	Detail     gjson.RawMessage `json:"detail"`
+}
+
+// detectEnvelope guesses the envelope of an SQS body from the fields SNS and EventBridge always set
+func detectEnvelope(body string) string {
+	var probe struct {
+		Type       string           `json:"Type"`
+		TopicArn   string           `json:"TopicArn"`
+		DetailType string           `json:"detail-type"`
+		Source     string           `json:"source"`
+		Detail     gjson.RawMessage `json:"detail"`
+	}
+	if err := gjson.Unmarshal([]byte(body), &probe); err != nil {
+		return EnvelopeRaw
+	}
+	if probe.Type != "" && probe.TopicArn != "" {
+		return EnvelopeSNS
+	}
+	if probe.DetailType != "" && probe.Source != "" && probe.Detail != nil {
+		return EnvelopeEventBridge
+	}
+	return EnvelopeRaw
+}
+
+func newMContext(ctx context.Context, x *Message, envelope string) *mContext {
+	if envelope == "" || envelope == EnvelopeAuto {
+		envelope = detectEnvelope(x.Body)
+	}
+
+	m := M{
+		ID:                x.ID,
+		ReceiptHandle:     x.ReceiptHandle,
+		Envelope:          envelope,
+		MessageAttributes: x.MessageAttributes,
+		Attributes:        x.Attributes,
+		message:           x,
+	}
+
+	switch envelope {
+	case EnvelopeSNS:
+		var n NotificationMessage
+		err := gjson.Unmarshal([]byte(x.Body), &n)
+		if err != nil {
+			log.Logger.Error("failed to unmarshal notification message", zap.Error(err))
+		}
+		m.Notification = n
+		m.RawBody = []byte(n.Body)
+	case EnvelopeEventBridge:
+		var e EventBridgeEvent
+		err := gjson.Unmarshal([]byte(x.Body), &e)
+		if err != nil {
+			log.Logger.Error("failed to unmarshal eventbridge event", zap.Error(err))
+		}
+		m.Event = &e
+		m.RawBody = e.Detail
+	default:
+		m.RawBody = []byte(x.Body)
+	}
+
+	body := make(map[string]interface{})
+	if len(bytes.TrimSpace(m.RawBody)) > 0 {
+		// raw bodies don't have to be JSON, the handler can still use RawBody
+		if err := gjson.Unmarshal(m.RawBody, &body); err != nil && envelope != EnvelopeRaw {
+			log.Logger.Error("failed to unmarshal notification body", zap.Error(err))
+		}
+	}
+	m.Body = body
+
+	reqID := "00000000000000000000000000000000"
+	if val, ok := m.Attribute(MessageAttributeReqID); ok {
+		reqID = val
+	}
+
+	return &mContext{
+		M:   m,
+		ctx: context.WithValue(ctx, log.RequestIDKey, reqID),
+	}
+}
+
+// Attribute returns a message attribute, looking at the SNS notification attributes first and the native SQS
+// message attributes second
+func (m *M) Attribute(name string) (string, bool) {
+	if val, ok := m.Notification.Attributes[name]; ok {
+		return val.Value, true
+	}
+	if val, ok := m.MessageAttributes[name]; ok {
+		return val.Value, true
+	}
+	return "", false
+}
+
+// SentAt returns when the message was originally published, or the zero time if that is unknown
+func (m *M) SentAt() time.Time {
+	switch {
+	case !m.Notification.Timestamp.IsZero():
+		return m.Notification.Timestamp
+	case m.Event != nil && !m.Event.Time.IsZero():
+		return m.Event.Time
+	}
+	if ms, err := strconv.ParseInt(m.Attributes["SentTimestamp"], 10, 64); err == nil {
+		return time.Unix(0, ms*int64(time.Millisecond))
+	}
+	return time.Time{}
+}
@@ -0,0 +1,206 @@
+package iq
+
+import (
+	"context"
+	gjson "encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+const (
+	FilterPolicyScopeMessageAttributes = "MessageAttributes"
+	FilterPolicyScopeMessageBody       = "MessageBody"
+)
+
+// FilterPolicy is an SNS subscription filter policy. Scope is FilterPolicyScopeMessageAttributes, the default, or
+// FilterPolicyScopeMessageBody to filter on the payload.
+type FilterPolicy struct {
+	Scope  string           `json:"scope,omitempty"`
+	Policy gjson.RawMessage `json:"policy"`
+}
+
+// FilterPolicies maps topic ARNs to the filter policy of the subscription to that topic. It decodes from a JSON
+// object so it can be set through the environment, e.g.
+//
+//	{"arn:aws:sns:us-east-1:123456789012:events": {"policy": {"x_msg_type": ["user.created"]}}}
+type FilterPolicies map[string]FilterPolicy
+
+// Decode implements envconfig.Decoder
+func (f *FilterPolicies) Decode(value string) error {
+	policies := make(map[string]FilterPolicy)
+	if err := gjson.Unmarshal([]byte(value), &policies); err != nil {
+		return errors.Wrap(err, "invalid filter policies")
+	}
+	*f = policies
+	return nil
+}
+
+// WithFilterPolicy sets the filter policy of the subscription to topicArn, replacing the one from Config.FilterPolicies
+func WithFilterPolicy(topicArn string, p FilterPolicy) Option {
+	return func(q *consumer) {
+		q.filterPolicies[topicArn] = p
+	}
+}
+
+// reconcileFilterPolicy makes the filter policy of a subscription match the configured one, removing the filter
+// policy of subscriptions that no longer have one configured
+func (q *consumer) reconcileFilterPolicy(ctx context.Context, subscriptionArn string, topicArn string) error {
+	current, err := q.transport.GetSubscriptionAttributes(ctx, subscriptionArn)
+	if err != nil {
+		return err
+	}
+
+	want, ok := q.filterPolicies[topicArn]
+	if !ok {
+		if current["FilterPolicy"] == "" || current["FilterPolicy"] == "{}" {
+			return nil
+		}
+		q.log.Info("removing subscription filter policy", zap.String("subscription", subscriptionArn))
+		return q.transport.SetSubscriptionAttributes(ctx, subscriptionArn, "FilterPolicy", "{}")
+	}
+
+	scope := want.Scope
+	if scope == "" {
+		scope = FilterPolicyScopeMessageAttributes
+	}
+	currentScope := current["FilterPolicyScope"]
+	if currentScope == "" {
+		currentScope = FilterPolicyScopeMessageAttributes
+	}
+
+	equal, err := jsonEqual(want.Policy, []byte(current["FilterPolicy"]))
+	if err != nil {
+		return errors.Wrap(err, "invalid filter policy for topic %s", topicArn)
+	}
+	if equal && scope == currentScope {
+		return nil
+	}
+
+	q.log.Info("updating subscription filter policy", zap.String("subscription", subscriptionArn),
+		zap.String("scope", scope), zap.ByteString("policy", want.Policy))
+	if scope != currentScope {
+		if err := q.transport.SetSubscriptionAttributes(ctx, subscriptionArn, "FilterPolicyScope", scope); err != nil {
+			return err
+		}
+	}
+	return q.transport.SetSubscriptionAttributes(ctx, subscriptionArn, "FilterPolicy", string(want.Policy))
+}
+
+func jsonEqual(want []byte, got []byte) (bool, error) {
+	var w, g interface{}
+	if err := gjson.Unmarshal(want, &w); err != nil {
+		return false, err
+	}
+	if len(got) == 0 {
+		return false, nil
+	}
+	if err := gjson.Unmarshal(got, &g); err != nil {
+		return false, nil
+	}
+	return reflect.DeepEqual(w, g), nil
+}
+
+// matchFilterPolicy implements the subset of SNS filter policies the MemoryTransport understands: exact values,
+// prefix, anything-but, exists and nested keys. An empty policy matches everything.
+func matchFilterPolicy(policy string, scope string, body string, attributes map[string]MessageAttribute) bool {
+	if policy == "" || policy == "{}" {
+		return true
+	}
+
+	var p map[string]interface{}
+	if err := gjson.Unmarshal([]byte(policy), &p); err != nil {
+		return false
+	}
+
+	doc := make(map[string]interface{})
+	if scope == FilterPolicyScopeMessageBody {
+		if err := gjson.Unmarshal([]byte(body), &doc); err != nil {
+			return false
+		}
+	} else {
+		for k, v := range attributes {
+			doc[k] = v.Value
+			if strings.HasPrefix(v.Type, "Number") {
+				if f, err := strconv.ParseFloat(v.Value, 64); err == nil {
+					doc[k] = f
+				}
+			}
+		}
+	}
+
+	return matchFilterObject(p, doc)
+}
+
+func matchFilterObject(policy map[string]interface{}, doc map[string]interface{}) bool {
+	for k, rules := range policy {
+		value, exists := doc[k]
+		switch r := rules.(type) {
+		case map[string]interface{}:
+			nested, ok := value.(map[string]interface{})
+			if !ok || !matchFilterObject(r, nested) {
+				return false
+			}
+		case []interface{}:
+			if !matchFilterRules(r, value, exists) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func matchFilterRules(rules []interface{}, value interface{}, exists bool) bool {
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	for _, rule := range rules {
+		if m, ok := rule.(map[string]interface{}); ok {
+			if e, ok := m["exists"].(bool); ok {
+				if e == exists {
+					return true
+				}
+				continue
+			}
+		}
+		if !exists {
+			continue
+		}
+		for _, v := range values {
+			if matchFilterRule(rule, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchFilterRule(rule interface{}, value interface{}) bool {
+	m, ok := rule.(map[string]interface{})
+	if !ok {
+		return reflect.DeepEqual(rule, value)
+	}
+	if prefix, ok := m["prefix"].(string); ok {
+		s, ok := value.(string)
+		return ok && strings.HasPrefix(s, prefix)
+	}
+	if but, ok := m["anything-but"]; ok {
+		if list, ok := but.([]interface{}); ok {
+			for _, x := range list {
+				if reflect.DeepEqual(x, value) {
+					return false
+				}
+			}
+			return true
+		}
+		return !reflect.DeepEqual(but, value)
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package iq
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterPolicies_Decode(t *testing.T) {
+	topic := NewMemoryTransport().TopicArn("events")
+	os.Setenv("IQ_PREFIX", "test")
+	os.Setenv("IQ_TOPIC_ARNS", topic)
+	os.Setenv("IQ_FILTER_POLICIES", `{"`+topic+`": {"scope": "MessageBody", "policy": {"name": ["value"]}}}`)
+	defer os.Unsetenv("IQ_PREFIX")
+	defer os.Unsetenv("IQ_TOPIC_ARNS")
+	defer os.Unsetenv("IQ_FILTER_POLICIES")
+
+	var c Config
+	require.NoError(t, envconfig.Process("IQ", &c))
+	require.Equal(t, FilterPolicyScopeMessageBody, c.FilterPolicies[topic].Scope)
+	require.JSONEq(t, `{"name": ["value"]}`, string(c.FilterPolicies[topic].Policy))
+
+	os.Setenv("IQ_FILTER_POLICIES", `not json`)
+	require.Error(t, envconfig.Process("IQ", &c))
+}
+
+func TestInstanceQ_FilterPolicies(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	users := transport.TopicArn("users")
+	orders := transport.TopicArn("orders")
+
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, testConfig(users, orders),
+		WithFilterPolicy(users, FilterPolicy{Policy: []byte(`{"x_msg_type": [{"prefix": "user."}]}`)}),
+		WithFilterPolicy(orders, FilterPolicy{
+			Scope:  FilterPolicyScopeMessageBody,
+			Policy: []byte(`{"order": {"total": [{"anything-but": 0}]}}`),
+		}),
+	)
+	require.NoError(t, err)
+	require.NoError(t, q.createQ(ctx))
+
+	publish := func(topic string, msgType string, body string) {
+		_, err := transport.Publish(ctx, &PublishInput{
+			TopicArn: topic,
+			Body:     body,
+			Attributes: map[string]MessageAttribute{
+				MessageAttributeType: {Type: "String", Value: msgType},
+			},
+		})
+		require.NoError(t, err)
+	}
+	publish(users, "user.created", `{}`)
+	publish(users, "audit.logged", `{}`)
+	publish(orders, "order.placed", `{"order": {"total": 10}}`)
+	publish(orders, "order.placed", `{"order": {"total": 0}}`)
+	require.Equal(t, 2, transport.Messages(q.qurl))
+
+	// a changed policy is reconciled onto the existing subscriptions, a removed one is cleared
+	q.filterPolicies = FilterPolicies{
+		users: {Policy: []byte(`{"x_msg_type": ["audit.logged"]}`)},
+	}
+	require.NoError(t, q.createQ(ctx))
+	attributes, err := transport.GetSubscriptionAttributes(ctx, q.subscriptions[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"x_msg_type": ["audit.logged"]}`, attributes["FilterPolicy"])
+	attributes, err = transport.GetSubscriptionAttributes(ctx, q.subscriptions[1])
+	require.NoError(t, err)
+	require.Equal(t, "{}", attributes["FilterPolicy"])
+
+	publish(users, "user.created", `{}`)
+	publish(users, "audit.logged", `{}`)
+	publish(orders, "order.placed", `{"order": {"total": 0}}`)
+	require.Equal(t, 4, transport.Messages(q.qurl))
+}
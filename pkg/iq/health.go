@@ -0,0 +1,122 @@
+package iq
+
+import (
+	gjson "encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+type HealthState string
+
+const (
+	HealthStateHealthy  HealthState = "healthy"
+	HealthStateDegraded HealthState = "degraded"
+	HealthStateFailed   HealthState = "failed"
+)
+
+// Health is a snapshot of the ability of a worker to receive messages
+type Health struct {
+	State               HealthState `json:"state"`
+	Running             bool        `json:"running"`
+	ConsecutiveFailures int         `json:"consecutive_failures"`
+	LastError           string      `json:"last_error,omitempty"`
+	LastErrorAt         time.Time   `json:"last_error_at,omitempty"`
+	LastReceiveAt       time.Time   `json:"last_receive_at,omitempty"`
+}
+
+type health struct {
+	mu          sync.Mutex
+	threshold   int
+	running     bool
+	failures    int
+	lastErr     error
+	lastErrAt   time.Time
+	lastReceive time.Time
+}
+
+func (h *health) started() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = true
+}
+
+func (h *health) stopped() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.running = false
+}
+
+func (h *health) success() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures = 0
+	h.lastReceive = time.Now()
+}
+
+func (h *health) failure(err error) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failures++
+	h.lastErr = err
+	h.lastErrAt = time.Now()
+	return h.failures
+}
+
+func (h *health) snapshot() Health {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := Health{
+		State:               HealthStateHealthy,
+		Running:             h.running,
+		ConsecutiveFailures: h.failures,
+		LastErrorAt:         h.lastErrAt,
+		LastReceiveAt:       h.lastReceive,
+	}
+	if h.lastErr != nil {
+		s.LastError = h.lastErr.Error()
+	}
+
+	switch {
+	case !h.running || (h.threshold > 0 && h.failures >= h.threshold):
+		s.State = HealthStateFailed
+	case h.failures > 0:
+		s.State = HealthStateDegraded
+	}
+
+	return s
+}
+
+// Health reports whether the worker is running and receiving messages. The worker is degraded while receives are
+// failing and failed once FailureThreshold consecutive receives failed, or when it is not running.
+func (q *consumer) Health() Health {
+	return q.health.snapshot()
+}
+
+// Ready returns an error when the worker is in the failed state, it is meant to back a readiness probe
+func (q *consumer) Ready() error {
+	h := q.Health()
+	if h.State == HealthStateFailed {
+		if !h.Running {
+			return errors.Wrapf("%s worker %s is not running", q.kind, q.name)
+		}
+		return errors.Wrapf("%s worker %s failed %d consecutive receives: %s", q.kind, q.name, h.ConsecutiveFailures, h.LastError)
+	}
+	return nil
+}
+
+// HealthHandler serves the Health of the worker as JSON, with a 503 status when the worker is not Ready
+func (q *consumer) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		if q.Ready() != nil {
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = gjson.NewEncoder(w).Encode(q.Health())
+	})
+}
@@ -0,0 +1,140 @@
+package iq
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DedupStore records the keys of messages that were handled successfully so duplicates can be skipped
+type DedupStore interface {
+	// Seen reports whether key was marked and its ttl has not expired yet
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark records key as handled for ttl
+	Mark(ctx context.Context, key string, ttl time.Duration) error
+}
+
+type idempotent struct {
+	store DedupStore
+	ttl   time.Duration
+	key   func(m *M) string
+}
+
+type IdempotencyOption func(*idempotent)
+
+// IdempotencyKey replaces the default key, the SNS message ID or the SQS message ID for messages that were not
+// delivered by SNS. When the store is shared by several InstanceQs the key has to include the instance, otherwise
+// only the first instance handles each message.
+func IdempotencyKey(fn func(m *M) string) IdempotencyOption {
+	return func(i *idempotent) {
+		i.key = fn
+	}
+}
+
+// Idempotent wraps a Handler so a message whose key is already in store is deleted without being handled again.
+// Keys are recorded for ttl once the Handler succeeds, a failed Handler leaves the message to be retried. Duplicates
+// that are handled at the same time are not detected.
+func Idempotent(store DedupStore, ttl time.Duration, opts ...IdempotencyOption) Middleware {
+	i := idempotent{
+		store: store,
+		ttl:   ttl,
+		key:   defaultIdempotencyKey,
+	}
+
+	for _, opt := range opts {
+		opt(&i)
+	}
+
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *M) error {
+			key := i.key(msg)
+			seen, err := i.store.Seen(ctx, key)
+			if err != nil {
+				return err
+			}
+			if seen {
+				GetLogger(ctx).Info("duplicate message skipped", zap.String("id", msg.ID), zap.String("key", key))
+				return nil
+			}
+
+			if err := next.HandleMessage(ctx, msg); err != nil {
+				return err
+			}
+
+			// the message was handled, failing now would only get it handled again
+			if err := i.store.Mark(ctx, key, i.ttl); err != nil {
+				GetLogger(ctx).Error("error marking message as handled", zap.Error(err), zap.String("key", key))
+			}
+			return nil
+		})
+	}
+}
+
+func defaultIdempotencyKey(m *M) string {
+	if m.Notification.ID != "" {
+		return m.Notification.ID
+	}
+	return m.ID
+}
+
+type dedupEntry struct {
+	key       string
+	expiresAt time.Time
+}
+
+// MemoryDedupStore is a DedupStore that keeps up to size keys in memory, evicting the least recently used key when
+// it is full
+type MemoryDedupStore struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func NewMemoryDedupStore(size int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (s *MemoryDedupStore) Seen(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(e.Value.(*dedupEntry).expiresAt) {
+		s.lru.Remove(e)
+		delete(s.entries, key)
+		return false, nil
+	}
+	s.lru.MoveToFront(e)
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Mark(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt := time.Now().Add(ttl)
+	if e, ok := s.entries[key]; ok {
+		e.Value.(*dedupEntry).expiresAt = expiresAt
+		s.lru.MoveToFront(e)
+		return nil
+	}
+
+	s.entries[key] = s.lru.PushFront(&dedupEntry{key: key, expiresAt: expiresAt})
+	for s.size > 0 && s.lru.Len() > s.size {
+		oldest := s.lru.Back()
+		s.lru.Remove(oldest)
+		delete(s.entries, oldest.Value.(*dedupEntry).key)
+	}
+	return nil
+}
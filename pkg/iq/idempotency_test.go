@@ -0,0 +1,57 @@
+package iq
+
+import (
+	"context"
+	goErrors "errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotent(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(10)
+
+	var calls int
+	fail := true
+	h := Idempotent(store, time.Minute)(HandlerFunc(func(ctx context.Context, msg *M) error {
+		calls++
+		if fail {
+			return goErrors.New("handler failure")
+		}
+		return nil
+	}))
+
+	m := &M{ID: "sqs-1", Notification: NotificationMessage{ID: "sns-1"}}
+	require.Error(t, h.HandleMessage(ctx, m))
+	fail = false
+	require.NoError(t, h.HandleMessage(ctx, m))
+	require.NoError(t, h.HandleMessage(ctx, &M{ID: "sqs-2", Notification: NotificationMessage{ID: "sns-1"}}))
+	require.Equal(t, 2, calls)
+
+	require.NoError(t, h.HandleMessage(ctx, &M{ID: "sqs-3"}))
+	require.Equal(t, 3, calls)
+}
+
+func TestMemoryDedupStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryDedupStore(2)
+
+	require.NoError(t, store.Mark(ctx, "a", time.Minute))
+	require.NoError(t, store.Mark(ctx, "b", time.Minute))
+	seen, err := store.Seen(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, seen)
+
+	// b is now the least recently used key
+	require.NoError(t, store.Mark(ctx, "c", time.Minute))
+	seen, _ = store.Seen(ctx, "b")
+	require.False(t, seen)
+	seen, _ = store.Seen(ctx, "a")
+	require.True(t, seen)
+
+	require.NoError(t, store.Mark(ctx, "d", -time.Second))
+	seen, _ = store.Seen(ctx, "d")
+	require.False(t, seen)
+}
@@ -2,6 +2,7 @@ package iq
 
 import (
 	"context"
+	gjson "encoding/json"
 	"time"
 )
 
@@ -14,13 +15,61 @@ type NotificationMessage struct {
 	Type             string                      `json:"Type"`
 	ID               string                      `json:"MessageId"`
 	TopicArn         string                      `json:"TopicArn"`
+	Subject          string                      `json:"Subject,omitempty"`
 	Body             string                      `json:"Message"`
 	Timestamp        time.Time                   `json:"Timestamp"`
 	SignatureVersion string                      `json:"SignatureVersion"`
 	Signature        string                      `json:"Signature"`
 	SigningCertURL   string                      `json:"SigningCertURL"`
 	UnsubscribeURL   string                      `json:"UnsubscribeURL"`
+	SubscribeURL     string                      `json:"SubscribeURL,omitempty"`
+	Token            string                      `json:"Token,omitempty"`
 	Attributes       map[string]MessageAttribute `json:"Attributes"`
+
+	// timestamp is the Timestamp exactly as SNS sent it, which is what the signature covers
+	timestamp string
+}
+
+// UnmarshalJSON accepts the attributes under "MessageAttributes" as well, which is where SNS puts them in the
+// notification envelope, and keeps the original Timestamp for signature verification
+func (n *NotificationMessage) UnmarshalJSON(b []byte) error {
+	type notification NotificationMessage
+	aux := struct {
+		*notification
+		Timestamp         string                      `json:"Timestamp"`
+		MessageAttributes map[string]MessageAttribute `json:"MessageAttributes"`
+	}{notification: (*notification)(n)}
+	if err := gjson.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+	if len(n.Attributes) == 0 {
+		n.Attributes = aux.MessageAttributes
+	}
+	n.timestamp = aux.Timestamp
+	if aux.Timestamp != "" {
+		ts, err := time.Parse(time.RFC3339Nano, aux.Timestamp)
+		if err != nil {
+			return err
+		}
+		n.Timestamp = ts
+	}
+	return nil
+}
+
+// snsTimestampFormat is the millisecond precision format SNS uses for Timestamp
+const snsTimestampFormat = "2006-01-02T15:04:05.000Z"
+
+// MarshalJSON writes Timestamp in the format SNS uses, so a marshalled message keeps a verifiable signature
+func (n NotificationMessage) MarshalJSON() ([]byte, error) {
+	type notification NotificationMessage
+	timestamp := n.timestamp
+	if timestamp == "" {
+		timestamp = n.Timestamp.UTC().Format(snsTimestampFormat)
+	}
+	return gjson.Marshal(struct {
+		notification
+		Timestamp string `json:"Timestamp"`
+	}{notification: notification(n), Timestamp: timestamp})
 }
 
 type M struct {
@@ -29,6 +78,17 @@ type M struct {
 	Body          map[string]interface{}
 	ID            string
 	RawBody       []byte
+
+	// Envelope is the format the message was decoded from, Notification is only set for EnvelopeSNS and Event only
+	// for EnvelopeEventBridge
+	Envelope string
+	Event    *EventBridgeEvent
+	// MessageAttributes are the native SQS message attributes, Attributes the SQS system attributes
+	MessageAttributes map[string]MessageAttribute
+	Attributes        map[string]string
+
+	// message is the transport message m was decoded from, kept so it can be re-sent as is
+	message *Message
 }
 
 type mContext struct {
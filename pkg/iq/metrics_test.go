@@ -0,0 +1,85 @@
+package iq
+
+import (
+	"context"
+	gjson "encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/errors"
+	"github.com/unanet/go/v2/pkg/metrics"
+)
+
+func TestInstanceQ_Metrics(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("metrics")
+	c := testConfig(topic)
+	// the metrics are global, a prefix of its own keeps other tests from counting
+	c.Prefix = "metrics_test"
+
+	var mu sync.Mutex
+	failed := false
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if msg.Body["job"] == "b" && !failed {
+			failed = true
+			return errors.Wrapf("job b failed")
+		}
+		return nil
+	})
+
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, c)
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, h))
+
+	for _, body := range []string{`{"job":"a"}`, `{"job":"b"}`} {
+		_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: body})
+		require.NoError(t, err)
+	}
+	// a notification published an hour ago, its age is read from the Timestamp of the notification
+	old, err := gjson.Marshal(NotificationMessage{
+		Type:      NotificationTypeNotification,
+		ID:        "old",
+		TopicArn:  topic,
+		Body:      `{"job":"old"}`,
+		Timestamp: time.Now().Add(-time.Hour).UTC(),
+	})
+	require.NoError(t, err)
+	_, err = transport.SendMessage(ctx, q.qurl, string(old), nil)
+	require.NoError(t, err)
+
+	deleted := metrics.StatIQMessagesDeletedCount.WithLabelValues(topic, c.Prefix)
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(deleted) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, q.Stop(ctx))
+
+	// b is received twice, once for the failed attempt and once for the redelivery
+	require.Equal(t, float64(4), testutil.ToFloat64(metrics.StatIQMessagesReceivedCount.WithLabelValues(topic, c.Prefix)))
+	require.Equal(t, float64(3), testutil.ToFloat64(metrics.StatIQMessagesHandledCount.WithLabelValues(topic, c.Prefix)))
+	require.Equal(t, float64(1), testutil.ToFloat64(metrics.StatIQMessagesFailedCount.WithLabelValues(topic, c.Prefix)))
+	require.Equal(t, float64(3), testutil.ToFloat64(deleted))
+	require.Equal(t, float64(0), testutil.ToFloat64(metrics.StatIQInFlightGauge.WithLabelValues(c.Prefix)))
+
+	handled := histogram(t, metrics.StatIQHandlerDurationHistogram.WithLabelValues(topic, c.Prefix))
+	require.Equal(t, uint64(4), handled.GetSampleCount())
+
+	age := histogram(t, metrics.StatIQMessageAgeHistogram.WithLabelValues(topic, c.Prefix))
+	require.Equal(t, uint64(4), age.GetSampleCount())
+	require.True(t, age.GetSampleSum() >= time.Hour.Seconds(), "the age of the old notification was not observed")
+	require.True(t, age.GetSampleSum() < time.Hour.Seconds()+60, "the age of the other notifications is too high")
+}
+
+func histogram(t *testing.T, o prometheus.Observer) *dto.Histogram {
+	var m dto.Metric
+	require.NoError(t, o.(prometheus.Metric).Write(&m))
+	return m.GetHistogram()
+}
@@ -0,0 +1,141 @@
+package iq
+
+import (
+	"context"
+	"runtime/debug"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/errors"
+	"github.com/unanet/go/v2/pkg/metrics"
+)
+
+// Middleware wraps a Handler to add behaviour around every message
+type Middleware func(Handler) Handler
+
+// Chain wraps h with mws, the first Middleware is the outermost
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// Use adds Middleware around the Handler passed to Start, in the order given. Recoverer is always the outermost
+// Middleware so a panicking Handler fails its message instead of the process. Use has to be called before Start.
+func (q *consumer) Use(mws ...Middleware) {
+	q.middlewares = append(q.middlewares, mws...)
+}
+
+func (q *consumer) chain(h Handler) Handler {
+	mws := append([]Middleware{Recoverer}, q.middlewares...)
+	return Chain(h, append(mws, applyTimeout)...)
+}
+
+// Recoverer turns a panic in the Handler into an error, so the message is retried like any other failure
+func Recoverer(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *M) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				GetLogger(ctx).Error("panic handling message",
+					zap.Any("panic", r),
+					zap.String("id", msg.ID),
+					zap.ByteString("stack", debug.Stack()),
+				)
+				err = errors.Wrapf("panic handling message %s: %v", msg.ID, r)
+			}
+		}()
+		return next.HandleMessage(ctx, msg)
+	})
+}
+
+// Logger logs the outcome and duration of every message with its req_id
+func Logger(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *M) error {
+		now := time.Now()
+		err := next.HandleMessage(ctx, msg)
+		l := GetLogger(ctx).With(
+			zap.String("id", msg.ID),
+			zap.String("msg_type", messageType(msg)),
+			zap.String("topic_arn", msg.Notification.TopicArn),
+			zap.Float64("elapsed_ms", float64(time.Since(now).Nanoseconds())/1000000.0),
+		)
+		if err != nil {
+			l.Error("message handler failed", zap.Error(err))
+			return err
+		}
+		l.Info("message handled")
+		return nil
+	})
+}
+
+type ctxKeyTimeout int
+
+const handlerTimeoutKey ctxKeyTimeout = 0
+
+// Timeout limits the time the Handler gets for each message to d. Within a consumer it replaces
+// Config.HandlerTimeout, so d may also be longer, the last Timeout of the chain wins.
+func Timeout(d time.Duration) Middleware {
+	return TimeoutFunc(func(*M) time.Duration {
+		return d
+	})
+}
+
+// TimeoutFunc is Timeout with a duration picked per message, a duration of 0 leaves the timeout unchanged
+func TimeoutFunc(fn func(msg *M) time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *M) error {
+			d := fn(msg)
+			if d <= 0 {
+				return next.HandleMessage(ctx, msg)
+			}
+			if _, ok := ctx.Value(handlerTimeoutKey).(time.Duration); ok {
+				// the consumer applies the timeout right before the Handler
+				return next.HandleMessage(context.WithValue(ctx, handlerTimeoutKey, d), msg)
+			}
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next.HandleMessage(ctx, msg)
+		})
+	}
+}
+
+// applyTimeout is the innermost Middleware of a consumer, it applies Config.HandlerTimeout or the duration a Timeout
+// replaced it with
+func applyTimeout(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *M) error {
+		if d, ok := ctx.Value(handlerTimeoutKey).(time.Duration); ok && d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+		}
+		return next.HandleMessage(ctx, msg)
+	})
+}
+
+// Metrics counts and times the messages handled by message type
+func Metrics(next Handler) Handler {
+	return HandlerFunc(func(ctx context.Context, msg *M) error {
+		now := time.Now()
+		msgType := messageType(msg)
+		err := next.HandleMessage(ctx, msg)
+		metrics.StatIQHandlerMessageTypeDurationHistogram.WithLabelValues(msgType).Observe(time.Since(now).Seconds())
+		status := "success"
+		if err != nil {
+			status = "error"
+		}
+		metrics.StatIQHandlerMessageTypeCount.WithLabelValues(msgType, status).Inc()
+		return err
+	})
+}
+
+func messageType(msg *M) string {
+	if t, ok := msg.Attribute(MessageAttributeType); ok {
+		return t
+	}
+	if msg.Event != nil {
+		return msg.Event.DetailType
+	}
+	return "unknown"
+}
@@ -0,0 +1,100 @@
+package iq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var order []string
+	mw := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, msg *M) error {
+				order = append(order, name)
+				return next.HandleMessage(ctx, msg)
+			})
+		}
+	}
+	h := Chain(HandlerFunc(func(ctx context.Context, msg *M) error {
+		order = append(order, "handler")
+		return nil
+	}), mw("first"), mw("second"))
+
+	require.NoError(t, h.HandleMessage(context.Background(), &M{}))
+	require.Equal(t, []string{"first", "second", "handler"}, order)
+}
+
+func TestInstanceQ_Middleware(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	var mu sync.Mutex
+	var calls int
+	deadlines := make(chan time.Duration, 1)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		if n == 1 {
+			panic("handler panic")
+		}
+		deadline, _ := ctx.Deadline()
+		deadlines <- time.Until(deadline)
+		return nil
+	})
+
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, testConfig(topic))
+	require.NoError(t, err)
+	q.Use(Logger, Metrics, Timeout(time.Second))
+	require.NoError(t, q.Start(ctx, h))
+	defer func() { _ = q.Stop(ctx) }()
+
+	_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{}`})
+	require.NoError(t, err)
+
+	select {
+	case d := <-deadlines:
+		require.True(t, d <= time.Second, "timeout was not shortened")
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not redelivered after the handler panicked")
+	}
+	require.Eventually(t, func() bool {
+		return transport.Messages(q.qurl) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestInstanceQ_TimeoutExtends(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	deadlines := make(chan time.Duration, 1)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		deadline, _ := ctx.Deadline()
+		deadlines <- time.Until(deadline)
+		return nil
+	})
+
+	cfg := testConfig(topic)
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, cfg)
+	require.NoError(t, err)
+	q.Use(Timeout(time.Duration(cfg.HandlerTimeout)*time.Second + time.Minute))
+	require.NoError(t, q.Start(ctx, h))
+	defer func() { _ = q.Stop(ctx) }()
+
+	_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{}`})
+	require.NoError(t, err)
+
+	select {
+	case d := <-deadlines:
+		require.True(t, d > time.Duration(cfg.HandlerTimeout)*time.Second, "timeout was not extended")
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not handled")
+	}
+}
@@ -0,0 +1,161 @@
+package iq
+
+import (
+	"context"
+	gjson "encoding/json"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/errors"
+	"github.com/unanet/go/v2/pkg/log"
+	"github.com/unanet/go/v2/pkg/metrics"
+)
+
+const maxPublishBatchSize = 10
+
+// PublishOption customizes a single published notification
+type PublishOption func(*PublishInput)
+
+// WithAttribute adds a String message attribute to the notification
+func WithAttribute(name string, value string) PublishOption {
+	return func(in *PublishInput) {
+		in.Attributes[name] = MessageAttribute{Type: "String", Value: value}
+	}
+}
+
+// WithMessageType sets the MessageAttributeType attribute a Router dispatches on
+func WithMessageType(msgType string) PublishOption {
+	return WithAttribute(MessageAttributeType, msgType)
+}
+
+// WithGroupID sets the message group of a notification published to a FIFO topic
+func WithGroupID(id string) PublishOption {
+	return func(in *PublishInput) {
+		in.GroupID = id
+	}
+}
+
+// WithDeduplicationID sets the deduplication id of a notification published to a FIFO topic
+func WithDeduplicationID(id string) PublishOption {
+	return func(in *PublishInput) {
+		in.DeduplicationID = id
+	}
+}
+
+// PublishEntry is one notification of a batch publish
+type PublishEntry struct {
+	Payload interface{}
+	Options []PublishOption
+}
+
+// Publisher sends notifications to SNS topics. Every notification carries the request ID of the publishing context
+// in the MessageAttributeReqID attribute, which the iq workers pick up on the receiving side.
+type Publisher struct {
+	transport Transport
+}
+
+func NewPublisher(sess *session.Session) *Publisher {
+	return NewPublisherWithTransport(NewAWSTransport(sess))
+}
+
+func NewPublisherWithTransport(t Transport) *Publisher {
+	return &Publisher{
+		transport: t,
+	}
+}
+
+// newPublishInput marshals payload to JSON, []byte and string payloads are sent as is
+func newPublishInput(ctx context.Context, topicArn string, payload interface{}, opts []PublishOption) (*PublishInput, error) {
+	var body string
+	switch p := payload.(type) {
+	case []byte:
+		body = string(p)
+	case string:
+		body = p
+	default:
+		b, err := gjson.Marshal(payload)
+		if err != nil {
+			return nil, errors.Wrap(err)
+		}
+		body = string(b)
+	}
+
+	in := PublishInput{
+		TopicArn:   topicArn,
+		Body:       body,
+		Attributes: make(map[string]MessageAttribute),
+	}
+	if reqID := log.GetReqID(ctx); len(reqID) > 0 {
+		in.Attributes[MessageAttributeReqID] = MessageAttribute{Type: "String", Value: reqID}
+	}
+	for _, opt := range opts {
+		opt(&in)
+	}
+
+	return &in, nil
+}
+
+// Publish sends payload to the topic and returns the message id assigned by SNS
+func (p *Publisher) Publish(ctx context.Context, topicArn string, payload interface{}, opts ...PublishOption) (string, error) {
+	in, err := newPublishInput(ctx, topicArn, payload, opts)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	id, err := p.transport.Publish(ctx, in)
+	metrics.StatIQPublishDurationHistogram.WithLabelValues(topicArn).Observe(time.Since(now).Seconds())
+	if err != nil {
+		metrics.StatIQPublishCount.WithLabelValues(topicArn, "failure").Inc()
+		GetLogger(ctx).Error("failed to publish notification", zap.String("topic_arn", topicArn), zap.Error(err))
+		return "", err
+	}
+	metrics.StatIQPublishCount.WithLabelValues(topicArn, "success").Inc()
+
+	GetLogger(ctx).Debug("notification published", zap.String("topic_arn", topicArn), zap.String("id", id))
+	return id, nil
+}
+
+// PublishBatch sends every entry to the topic in batches of 10. The results are in the same order as the entries,
+// a failed entry does not stop the remaining ones from being published.
+func (p *Publisher) PublishBatch(ctx context.Context, topicArn string, entries []PublishEntry) ([]PublishResult, error) {
+	inputs := make([]*PublishInput, len(entries))
+	for i, e := range entries {
+		in, err := newPublishInput(ctx, topicArn, e.Payload, e.Options)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = in
+	}
+
+	var results []PublishResult
+	for start := 0; start < len(inputs); start += maxPublishBatchSize {
+		end := start + maxPublishBatchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+
+		now := time.Now()
+		r, err := p.transport.PublishBatch(ctx, topicArn, inputs[start:end])
+		metrics.StatIQPublishDurationHistogram.WithLabelValues(topicArn).Observe(time.Since(now).Seconds())
+		if err != nil {
+			metrics.StatIQPublishCount.WithLabelValues(topicArn, "failure").Add(float64(end - start))
+			GetLogger(ctx).Error("failed to publish notification batch", zap.String("topic_arn", topicArn), zap.Error(err))
+			return results, err
+		}
+
+		for _, x := range r {
+			if x.Err != nil {
+				metrics.StatIQPublishCount.WithLabelValues(topicArn, "failure").Inc()
+				GetLogger(ctx).Error("failed to publish notification", zap.String("topic_arn", topicArn), zap.Error(x.Err))
+			} else {
+				metrics.StatIQPublishCount.WithLabelValues(topicArn, "success").Inc()
+			}
+		}
+		results = append(results, r...)
+	}
+
+	return results, nil
+}
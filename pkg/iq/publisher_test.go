@@ -0,0 +1,58 @@
+package iq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/log"
+)
+
+func TestPublisher(t *testing.T) {
+	ctx := context.WithValue(context.Background(), log.RequestIDKey, "req-123")
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+	fifo := transport.TopicArn("events.fifo")
+
+	type delivery struct {
+		m     *M
+		reqID string
+	}
+	received := make(chan delivery, 10)
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, testConfig(topic, fifo))
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, HandlerFunc(func(ctx context.Context, msg *M) error {
+		received <- delivery{m: msg, reqID: log.GetReqID(ctx)}
+		return nil
+	})))
+	defer func() { _ = q.Stop(ctx) }()
+
+	p := NewPublisherWithTransport(transport)
+	_, err = p.Publish(ctx, topic, userCreated{Name: "alice"}, WithMessageType("user.created"))
+	require.NoError(t, err)
+
+	results, err := p.PublishBatch(ctx, fifo, []PublishEntry{
+		{Payload: userCreated{Name: "bob"}, Options: []PublishOption{WithGroupID("g"), WithDeduplicationID("1")}},
+		{Payload: userCreated{Name: "bob"}, Options: []PublishOption{WithGroupID("g"), WithDeduplicationID("1")}},
+		{Payload: userCreated{Name: "carol"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	require.NoError(t, results[0].Err)
+	require.Equal(t, results[0].ID, results[1].ID)
+	require.Error(t, results[2].Err)
+
+	names := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		select {
+		case d := <-received:
+			require.Equal(t, "req-123", d.reqID)
+			names[d.m.Body["name"].(string)] = d.m.Notification.Attributes[MessageAttributeType].Value
+		case <-time.After(2 * time.Second):
+			t.Fatal("published notification was not received")
+		}
+	}
+	require.Equal(t, map[string]string{"alice": "user.created", "bob": ""}, names)
+}
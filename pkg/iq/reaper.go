@@ -0,0 +1,194 @@
+package iq
+
+import (
+	"context"
+	goErrors "errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"go.uber.org/zap"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/unanet/go/v2/pkg/errors"
+	"github.com/unanet/go/v2/pkg/k8s"
+	"github.com/unanet/go/v2/pkg/log"
+)
+
+// InstanceChecker reports whether the instance an InstanceQ was created for is still running
+type InstanceChecker func(ctx context.Context, instanceName string) (bool, error)
+
+// PodChecker is an InstanceChecker for instances named after their pod, which is what GetUniqueName returns when
+// running in Kubernetes
+func PodChecker(client kubernetes.Interface, namespace string) InstanceChecker {
+	return func(ctx context.Context, instanceName string) (bool, error) {
+		return k8s.PodExists(ctx, client, namespace, instanceName)
+	}
+}
+
+// ReapedQueue is an orphaned instance queue found by the Reaper. Err is set when the queue could not be checked or
+// removed.
+type ReapedQueue struct {
+	Name            string
+	InstanceName    string
+	URL             string
+	DeadLetterQueue string
+	Subscriptions   []string
+	Err             error
+}
+
+// ReapReport lists the instance queues a Reaper removed, or would have removed in dry-run mode
+type ReapReport struct {
+	DryRun  bool
+	Checked int
+	Reaped  []ReapedQueue
+}
+
+// Reaper removes the queues, dead-letter queues and subscriptions of InstanceQs whose instance no longer exists,
+// e.g. because the pod was killed before Stop could clean up. Only one Reaper should run at a time per prefix.
+type Reaper struct {
+	log       *zap.Logger
+	transport Transport
+	prefix    string
+	exists    InstanceChecker
+	dryRun    bool
+}
+
+type ReaperOption func(*Reaper)
+
+// DryRun makes the Reaper report the queues it would remove without removing anything
+func DryRun() ReaperOption {
+	return func(r *Reaper) {
+		r.dryRun = true
+	}
+}
+
+func NewReaper(prefix string, sess *session.Session, exists InstanceChecker, opts ...ReaperOption) *Reaper {
+	return NewReaperWithTransport(prefix, NewAWSTransport(sess), exists, opts...)
+}
+
+func NewReaperWithTransport(prefix string, t Transport, exists InstanceChecker, opts ...ReaperOption) *Reaper {
+	r := Reaper{
+		log:       log.Logger.With(zap.String("reaper", prefix)),
+		transport: t,
+		prefix:    prefix,
+		exists:    exists,
+	}
+
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	return &r
+}
+
+// Reap checks every instance queue tagged with the prefix of the Reaper and removes the ones whose instance is
+// gone. A queue that fails to be checked or removed doesn't stop the others, Reap returns an error once all of
+// them were checked if any of them failed.
+func (r *Reaper) Reap(ctx context.Context) (*ReapReport, error) {
+	report := ReapReport{DryRun: r.dryRun}
+
+	namePrefix := fmt.Sprintf("%s_srv-", r.prefix)
+	qurls, err := r.transport.ListQueues(ctx, namePrefix)
+	if err != nil {
+		return &report, err
+	}
+
+	var subscriptions []*Subscription
+	var failed int
+	for _, qurl := range qurls {
+		name := qurl[strings.LastIndex(qurl, "/")+1:]
+		if strings.HasSuffix(name, "_dlq") {
+			// removed together with the queue it belongs to
+			continue
+		}
+
+		tags, err := r.transport.QueueTags(ctx, qurl)
+		if err != nil {
+			r.log.Error("error reading queue tags", zap.Error(err), zap.String("qurl", qurl))
+			failed++
+			continue
+		}
+		if tags["Prefix"] != r.prefix {
+			continue
+		}
+		report.Checked++
+
+		instanceName := strings.TrimPrefix(name, namePrefix)
+		exists, err := r.exists(ctx, instanceName)
+		if err != nil {
+			r.log.Error("error checking instance", zap.Error(err), zap.String("instance", instanceName))
+			failed++
+			continue
+		}
+		if exists {
+			continue
+		}
+
+		if subscriptions == nil {
+			subscriptions, err = r.transport.ListSubscriptions(ctx)
+			if err != nil {
+				return &report, err
+			}
+		}
+
+		reaped := r.reap(ctx, ReapedQueue{Name: name, InstanceName: instanceName, URL: qurl}, subscriptions)
+		if reaped.Err != nil {
+			failed++
+		}
+		report.Reaped = append(report.Reaped, reaped)
+	}
+
+	if failed > 0 {
+		return &report, errors.Wrapf("failed to reap %d instance queues", failed)
+	}
+	return &report, nil
+}
+
+func (r *Reaper) reap(ctx context.Context, q ReapedQueue, subscriptions []*Subscription) ReapedQueue {
+	l := r.log.With(zap.String("qurl", q.URL), zap.Bool("dry_run", r.dryRun))
+
+	attributes, err := r.transport.GetQueueAttributes(ctx, q.URL)
+	if err != nil {
+		q.Err = err
+		return q
+	}
+	for _, x := range subscriptions {
+		if x.Endpoint == attributes["QueueArn"] {
+			q.Subscriptions = append(q.Subscriptions, x.ARN)
+		}
+	}
+
+	dlq, err := r.transport.GetQueue(ctx, fmt.Sprintf("%s_dlq", q.Name))
+	if err == nil {
+		q.DeadLetterQueue = dlq.URL
+	} else if !goErrors.Is(err, ErrQueueDoesNotExist) {
+		q.Err = err
+		return q
+	}
+
+	if r.dryRun {
+		l.Info("orphaned instance queue found")
+		return q
+	}
+
+	for _, x := range q.Subscriptions {
+		if err := r.transport.Unsubscribe(ctx, x); err != nil {
+			q.Err = err
+			return q
+		}
+	}
+	if err := r.transport.DeleteQueue(ctx, q.URL); err != nil {
+		q.Err = err
+		return q
+	}
+	if q.DeadLetterQueue != "" {
+		if err := r.transport.DeleteQueue(ctx, q.DeadLetterQueue); err != nil {
+			q.Err = err
+			return q
+		}
+	}
+
+	l.Info("orphaned instance queue removed", zap.Strings("subscriptions", q.Subscriptions))
+	return q
+}
@@ -0,0 +1,58 @@
+package iq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaper(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	c := testConfig(topic)
+	c.DeadLetterQueue = true
+	alive, err := NewInstanceQWithTransport("svc-abc123-alive", transport, c)
+	require.NoError(t, err)
+	require.NoError(t, alive.createQ(ctx))
+	dead, err := NewInstanceQWithTransport("svc-abc123-dead", transport, c)
+	require.NoError(t, err)
+	require.NoError(t, dead.createQ(ctx))
+	oc := testConfig(topic)
+	oc.Prefix = "other"
+	other, err := NewInstanceQWithTransport("svc-abc123-other", transport, oc)
+	require.NoError(t, err)
+	require.NoError(t, other.createQ(ctx))
+
+	exists := func(ctx context.Context, instanceName string) (bool, error) {
+		return instanceName == "svc-abc123-alive", nil
+	}
+
+	report, err := NewReaperWithTransport("test", transport, exists, DryRun()).Reap(ctx)
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Equal(t, 2, report.Checked)
+	require.Len(t, report.Reaped, 1)
+	require.Equal(t, "svc-abc123-dead", report.Reaped[0].InstanceName)
+	require.Equal(t, dead.qurl, report.Reaped[0].URL)
+	require.Equal(t, dead.dlqurl, report.Reaped[0].DeadLetterQueue)
+	require.Equal(t, dead.subscriptions, report.Reaped[0].Subscriptions)
+	_, err = transport.GetQueue(ctx, "test_srv-svc-abc123-dead")
+	require.NoError(t, err)
+
+	report, err = NewReaperWithTransport("test", transport, exists).Reap(ctx)
+	require.NoError(t, err)
+	require.Len(t, report.Reaped, 1)
+
+	qurls, err := transport.ListQueues(ctx, "")
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{alive.qurl, alive.dlqurl, other.qurl}, qurls)
+	subscriptions, err := transport.ListSubscriptions(ctx)
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 2)
+	for _, x := range subscriptions {
+		require.NotEqual(t, dead.qarn, x.Endpoint)
+	}
+}
@@ -0,0 +1,134 @@
+package iq
+
+import (
+	"context"
+	gjson "encoding/json"
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+const (
+	MessageAttributeType string = "x_msg_type"
+)
+
+// TypedHandlerFunc handles a message after its RawBody was decoded into v, which is a pointer to a new value of the
+// type the handler was registered with
+type TypedHandlerFunc func(ctx context.Context, msg *M, v interface{}) error
+
+type route struct {
+	typ reflect.Type
+	fn  TypedHandlerFunc
+}
+
+// Router is a Handler that dispatches messages to the handler registered for their type. The type is taken from
+// the MessageAttributeType message attribute, from a body field when TypeField is used, or from the detail-type of
+// EventBridge events.
+type Router struct {
+	attribute string
+	field     string
+	routes    map[string]route
+	notFound  Handler
+}
+
+type RouterOption func(*Router)
+
+// TypeAttribute reads the message type from the named SNS message attribute
+func TypeAttribute(name string) RouterOption {
+	return func(r *Router) {
+		r.attribute = name
+	}
+}
+
+// TypeField reads the message type from the named top level field of the message body, it is used when the type
+// attribute is not present
+func TypeField(name string) RouterOption {
+	return func(r *Router) {
+		r.field = name
+	}
+}
+
+// NotFound sets the Handler used for messages without a registered type. By default they are logged and dropped.
+func NotFound(h Handler) RouterOption {
+	return func(r *Router) {
+		r.notFound = h
+	}
+}
+
+func NewRouter(opts ...RouterOption) *Router {
+	r := Router{
+		attribute: MessageAttributeType,
+		routes:    make(map[string]route),
+		notFound:  HandlerFunc(dropUnknown),
+	}
+
+	for _, opt := range opts {
+		opt(&r)
+	}
+
+	return &r
+}
+
+func dropUnknown(ctx context.Context, msg *M) error {
+	GetLogger(ctx).Warn("no handler registered for message type, dropping message", zap.String("id", msg.ID))
+	return nil
+}
+
+// Handle registers fn for msgType. v is a value or pointer of the Go type the message body is decoded into, e.g.
+// UserCreated{}; fn receives a *UserCreated. Handle panics when v is nil, use HandleFunc for undecoded messages.
+func (r *Router) Handle(msgType string, v interface{}, fn TypedHandlerFunc) {
+	if v == nil {
+		panic(fmt.Sprintf("iq: nil value for message type %q, pass a value of the type to decode into", msgType))
+	}
+	typ := reflect.TypeOf(v)
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	r.routes[msgType] = route{typ: typ, fn: fn}
+}
+
+// HandleFunc registers a Handler for msgType that works on the undecoded message
+func (r *Router) HandleFunc(msgType string, fn HandlerFunc) {
+	r.routes[msgType] = route{fn: func(ctx context.Context, msg *M, v interface{}) error {
+		return fn(ctx, msg)
+	}}
+}
+
+// Type returns the type of msg as the Router resolves it
+func (r *Router) Type(msg *M) string {
+	if r.attribute != "" {
+		if val, ok := msg.Attribute(r.attribute); ok {
+			return val
+		}
+	}
+	if r.field != "" {
+		if val, ok := msg.Body[r.field]; ok {
+			return fmt.Sprintf("%v", val)
+		}
+	}
+	if msg.Event != nil {
+		return msg.Event.DetailType
+	}
+	return ""
+}
+
+func (r *Router) HandleMessage(ctx context.Context, msg *M) error {
+	rt, ok := r.routes[r.Type(msg)]
+	if !ok {
+		return r.notFound.HandleMessage(ctx, msg)
+	}
+
+	if rt.typ == nil {
+		return rt.fn(ctx, msg, nil)
+	}
+
+	v := reflect.New(rt.typ).Interface()
+	if err := gjson.Unmarshal(msg.RawBody, v); err != nil {
+		return errors.Wrap(err, "failed to decode %s message %s", r.Type(msg), msg.ID)
+	}
+
+	return rt.fn(ctx, msg, v)
+}
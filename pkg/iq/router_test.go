@@ -0,0 +1,58 @@
+package iq
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type userCreated struct {
+	Name string `json:"name"`
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+
+	var got *userCreated
+	var unknown int
+	r := NewRouter(TypeField("type"), NotFound(HandlerFunc(func(ctx context.Context, msg *M) error {
+		unknown++
+		return nil
+	})))
+	r.Handle("user.created", userCreated{}, func(ctx context.Context, msg *M, v interface{}) error {
+		got = v.(*userCreated)
+		return nil
+	})
+
+	byAttribute := &M{
+		Notification: NotificationMessage{Attributes: map[string]MessageAttribute{
+			MessageAttributeType: {Type: "String", Value: "user.created"},
+		}},
+		RawBody: []byte(`{"name":"alice"}`),
+	}
+	require.NoError(t, r.HandleMessage(ctx, byAttribute))
+	require.Equal(t, "alice", got.Name)
+
+	byField := &M{
+		Body:    map[string]interface{}{"type": "user.created"},
+		RawBody: []byte(`{"type":"user.created","name":"bob"}`),
+	}
+	require.NoError(t, r.HandleMessage(ctx, byField))
+	require.Equal(t, "bob", got.Name)
+
+	require.NoError(t, r.HandleMessage(ctx, &M{RawBody: []byte(`{}`)}))
+	require.Equal(t, 1, unknown)
+
+	require.Error(t, r.HandleMessage(ctx, &M{Body: map[string]interface{}{"type": "user.created"}, RawBody: []byte(`[`)}))
+}
+
+func TestRouter_HandleNil(t *testing.T) {
+	r := NewRouter()
+	require.PanicsWithValue(t, `iq: nil value for message type "user.created", pass a value of the type to decode into`, func() {
+		r.Handle("user.created", nil, func(ctx context.Context, msg *M, v interface{}) error { return nil })
+	})
+	require.NotPanics(t, func() {
+		r.Handle("user.created", (*userCreated)(nil), func(ctx context.Context, msg *M, v interface{}) error { return nil })
+	})
+}
@@ -0,0 +1,208 @@
+package iq
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+// DefaultSigningCertHosts matches the hosts SNS serves its signing certificates from
+var DefaultSigningCertHosts = regexp.MustCompile(`^sns\.[a-z0-9\-]+\.amazonaws\.com(\.cn)?$`)
+
+const (
+	NotificationTypeNotification             = "Notification"
+	NotificationTypeSubscriptionConfirmation = "SubscriptionConfirmation"
+	NotificationTypeUnsubscribeConfirmation  = "UnsubscribeConfirmation"
+)
+
+// SignatureVerifier checks the signature SNS attaches to every NotificationMessage against the certificate
+// referenced by SigningCertURL. Certificates are only fetched from allowed hosts over https and are cached by URL.
+type SignatureVerifier struct {
+	hosts  *regexp.Regexp
+	client *http.Client
+	mu     sync.RWMutex
+	certs  map[string]*x509.Certificate
+}
+
+type VerifierOption func(*SignatureVerifier)
+
+// AllowedCertHosts replaces DefaultSigningCertHosts as the pattern signing certificate hosts have to match
+func AllowedCertHosts(hosts *regexp.Regexp) VerifierOption {
+	return func(v *SignatureVerifier) {
+		v.hosts = hosts
+	}
+}
+
+// VerifierHTTPClient sets the client used to download signing certificates
+func VerifierHTTPClient(c *http.Client) VerifierOption {
+	return func(v *SignatureVerifier) {
+		v.client = c
+	}
+}
+
+// VerifierCertificate seeds the certificate cache, which lets the verifier run without network access
+func VerifierCertificate(certURL string, cert *x509.Certificate) VerifierOption {
+	return func(v *SignatureVerifier) {
+		v.certs[certURL] = cert
+	}
+}
+
+func NewSignatureVerifier(opts ...VerifierOption) *SignatureVerifier {
+	v := SignatureVerifier{
+		hosts:  DefaultSigningCertHosts,
+		client: &http.Client{Timeout: 10 * time.Second},
+		certs:  make(map[string]*x509.Certificate),
+	}
+
+	for _, opt := range opts {
+		opt(&v)
+	}
+
+	return &v
+}
+
+// Verify returns an error unless n carries a valid SignatureVersion 1 or 2 signature
+func (v *SignatureVerifier) Verify(ctx context.Context, n *NotificationMessage) error {
+	var hash crypto.Hash
+	switch n.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return errors.Wrapf("unsupported signature version %q", n.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(n.Signature)
+	if err != nil {
+		return errors.Wrap(err, "invalid signature encoding")
+	}
+
+	cert, err := v.certificate(ctx, n.SigningCertURL)
+	if err != nil {
+		return err
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.Wrapf("signing certificate %s does not hold an RSA key", n.SigningCertURL)
+	}
+
+	sum := digest(hash, canonicalString(n))
+	if err := rsa.VerifyPKCS1v15(pub, hash, sum, signature); err != nil {
+		return errors.Wrap(err, "invalid signature for message %s", n.ID)
+	}
+
+	return nil
+}
+
+// AllowedURL reports whether u is an https URL on one of the allowed SNS hosts
+func (v *SignatureVerifier) AllowedURL(u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return parsed.Scheme == "https" && v.hosts.MatchString(parsed.Hostname())
+}
+
+func (v *SignatureVerifier) certificate(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	v.mu.RLock()
+	cert, ok := v.certs[certURL]
+	v.mu.RUnlock()
+	if ok {
+		return cert, nil
+	}
+
+	if !v.AllowedURL(certURL) || !strings.HasSuffix(certURL, ".pem") {
+		return nil, errors.Wrapf("signing certificate url %q is not allowed", certURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.UnexpectedStatusCode(resp.StatusCode, nil)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.Wrapf("signing certificate %s is not PEM encoded", certURL)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	v.mu.Lock()
+	v.certs[certURL] = cert
+	v.mu.Unlock()
+
+	return cert, nil
+}
+
+// canonicalString builds the string SNS signs, the fields depend on the message type
+func canonicalString(n *NotificationMessage) string {
+	timestamp := n.timestamp
+	if timestamp == "" {
+		timestamp = n.Timestamp.UTC().Format(snsTimestampFormat)
+	}
+
+	var b strings.Builder
+	add := func(k, v string) {
+		b.WriteString(k)
+		b.WriteString("\n")
+		b.WriteString(v)
+		b.WriteString("\n")
+	}
+
+	add("Message", n.Body)
+	add("MessageId", n.ID)
+	if n.Type == NotificationTypeNotification {
+		if n.Subject != "" {
+			add("Subject", n.Subject)
+		}
+	} else {
+		add("SubscribeURL", n.SubscribeURL)
+	}
+	add("Timestamp", timestamp)
+	if n.Type != NotificationTypeNotification {
+		add("Token", n.Token)
+	}
+	add("TopicArn", n.TopicArn)
+	add("Type", n.Type)
+
+	return b.String()
+}
+
+func digest(hash crypto.Hash, s string) []byte {
+	if hash == crypto.SHA1 {
+		d := sha1.Sum([]byte(s))
+		return d[:]
+	}
+	d := sha256.Sum256([]byte(s))
+	return d[:]
+}
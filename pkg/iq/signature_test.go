@@ -0,0 +1,163 @@
+package iq
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	gjson "encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+
+func testSigningCert(t *testing.T) (*rsa.PrivateKey, *x509.Certificate) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return key, cert
+}
+
+func signNotification(t *testing.T, key *rsa.PrivateKey, n *NotificationMessage) {
+	hash := crypto.SHA1
+	if n.SignatureVersion == "2" {
+		hash = crypto.SHA256
+	}
+	n.SigningCertURL = testCertURL
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest(hash, canonicalString(n)))
+	require.NoError(t, err)
+	n.Signature = base64.StdEncoding.EncodeToString(sig)
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	ctx := context.Background()
+	key, cert := testSigningCert(t)
+	v := NewSignatureVerifier(VerifierCertificate(testCertURL, cert))
+
+	for _, version := range []string{"1", "2"} {
+		n := NotificationMessage{
+			Type:             NotificationTypeNotification,
+			ID:               "id-" + version,
+			TopicArn:         "arn:aws:sns:us-east-1:000000000000:events",
+			Subject:          "subject",
+			Body:             `{"name":"value"}`,
+			Timestamp:        time.Date(2021, 1, 2, 3, 4, 5, 60000000, time.UTC),
+			SignatureVersion: version,
+		}
+		signNotification(t, key, &n)
+
+		// the signature has to survive the round trip through the JSON envelope
+		b, err := gjson.Marshal(n)
+		require.NoError(t, err)
+		var received NotificationMessage
+		require.NoError(t, gjson.Unmarshal(b, &received))
+		require.NoError(t, v.Verify(ctx, &received))
+
+		received.Body = `{"name":"tampered"}`
+		require.Error(t, v.Verify(ctx, &received))
+	}
+
+	n := NotificationMessage{Type: NotificationTypeNotification, SignatureVersion: "1"}
+	signNotification(t, key, &n)
+	n.SigningCertURL = "https://attacker.example.com/cert.pem"
+	require.Error(t, v.Verify(ctx, &n))
+}
+
+func TestSNSHandler(t *testing.T) {
+	key, cert := testSigningCert(t)
+
+	confirmed := make(chan struct{}, 1)
+	sns := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		confirmed <- struct{}{}
+	}))
+	defer sns.Close()
+
+	v := NewSignatureVerifier(
+		VerifierCertificate(testCertURL, cert),
+		VerifierHTTPClient(sns.Client()),
+		AllowedCertHosts(regexp.MustCompile(`^(sns\.us-east-1\.amazonaws\.com|127\.0\.0\.1)$`)),
+	)
+	handled := make(chan *M, 1)
+	h := NewSNSHandler(HandlerFunc(func(ctx context.Context, msg *M) error {
+		handled <- msg
+		return nil
+	}), v)
+
+	post := func(n NotificationMessage) int {
+		b, err := gjson.Marshal(n)
+		require.NoError(t, err)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sns", bytes.NewReader(b)))
+		return w.Code
+	}
+
+	confirmation := NotificationMessage{
+		Type:             NotificationTypeSubscriptionConfirmation,
+		ID:               "confirm",
+		Token:            "token",
+		SubscribeURL:     sns.URL + "/confirm",
+		Timestamp:        time.Now().UTC(),
+		SignatureVersion: "2",
+	}
+	signNotification(t, key, &confirmation)
+	require.Equal(t, http.StatusOK, post(confirmation))
+	require.Len(t, confirmed, 1)
+
+	notification := NotificationMessage{
+		Type:             NotificationTypeNotification,
+		ID:               "notification",
+		Body:             `{"name":"value"}`,
+		Timestamp:        time.Now().UTC(),
+		SignatureVersion: "1",
+	}
+	signNotification(t, key, &notification)
+	require.Equal(t, http.StatusOK, post(notification))
+	require.Equal(t, "value", (<-handled).Body["name"])
+
+	notification.Body = `{"name":"tampered"}`
+	require.Equal(t, http.StatusForbidden, post(notification))
+
+	// a message of the maximum size fits into its envelope, even when every byte of it is escaped as \uXXXX
+	notification.Body = `{"name":"` + strings.Repeat(`<`, 256*1024-12) + `"}`
+	signNotification(t, key, &notification)
+	b, err := gjson.Marshal(notification)
+	require.NoError(t, err)
+	require.True(t, len(b) > 6*256*1024-100, "the padding was not escaped")
+	require.Equal(t, http.StatusOK, post(notification))
+	require.Len(t, (<-handled).Body["name"], 256*1024-12)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sns", bytes.NewReader(make([]byte, maxNotificationSize+1))))
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// without a verifier anybody could subscribe the endpoint to their topics
+	confirmation.ID = "unverified"
+	unverified := NewSNSHandler(HandlerFunc(func(ctx context.Context, msg *M) error { return nil }), nil)
+	b, err = gjson.Marshal(confirmation)
+	require.NoError(t, err)
+	w = httptest.NewRecorder()
+	unverified.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sns", bytes.NewReader(b)))
+	require.Equal(t, http.StatusForbidden, w.Code)
+	require.Len(t, confirmed, 1, "only the verified subscription was confirmed")
+}
@@ -0,0 +1,115 @@
+package iq
+
+import (
+	gjson "encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// maxNotificationSize bounds the request body of a notification. SNS messages are at most 256KiB, in the envelope
+// every byte of the message may be escaped as \uXXXX, six bytes, and the attributes, the signature and the urls need
+// less than another 64KiB.
+const maxNotificationSize = 6*256*1024 + 64*1024
+
+// SNSHandler is an http.Handler for SNS HTTP/HTTPS subscriptions. It passes notifications to a Handler, answering
+// 500 when the Handler fails so SNS retries the delivery. Signatures are checked when the SNSHandler is created with
+// a SignatureVerifier, subscription requests are only confirmed then, so nobody can subscribe the endpoint to a topic
+// of their own.
+type SNSHandler struct {
+	handler  Handler
+	verifier *SignatureVerifier
+	verify   bool
+}
+
+func NewSNSHandler(h Handler, v *SignatureVerifier) *SNSHandler {
+	s := SNSHandler{
+		handler:  h,
+		verifier: v,
+		verify:   v != nil,
+	}
+	if s.verifier == nil {
+		// still needed to restrict which SubscribeURL hosts get confirmed
+		s.verifier = NewSignatureVerifier()
+	}
+	return &s
+}
+
+func (s *SNSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxNotificationSize+1))
+	if err != nil {
+		http.Error(w, "failed to read notification", http.StatusBadRequest)
+		return
+	}
+	if len(b) > maxNotificationSize {
+		GetLogger(ctx).Warn("sns notification too large", zap.Int64("content_length", r.ContentLength))
+		http.Error(w, "notification too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var n NotificationMessage
+	if err := gjson.Unmarshal(b, &n); err != nil {
+		http.Error(w, "invalid notification", http.StatusBadRequest)
+		return
+	}
+
+	l := GetLogger(ctx).With(zap.String("id", n.ID), zap.String("topic_arn", n.TopicArn))
+
+	if s.verify {
+		if err := s.verifier.Verify(ctx, &n); err != nil {
+			l.Warn("sns notification failed signature verification", zap.Error(err))
+			http.Error(w, "invalid signature", http.StatusForbidden)
+			return
+		}
+	}
+
+	switch n.Type {
+	case NotificationTypeSubscriptionConfirmation:
+		if !s.verify {
+			l.Warn("sns subscription not confirmed without a signature verifier")
+			http.Error(w, "subscription confirmation requires a signature verifier", http.StatusForbidden)
+			return
+		}
+		if !s.verifier.AllowedURL(n.SubscribeURL) {
+			l.Warn("sns subscribe url is not allowed", zap.String("url", n.SubscribeURL))
+			http.Error(w, "subscribe url not allowed", http.StatusBadRequest)
+			return
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, n.SubscribeURL, nil)
+		if err != nil {
+			http.Error(w, "invalid subscribe url", http.StatusBadRequest)
+			return
+		}
+		resp, err := s.verifier.client.Do(req)
+		if err != nil {
+			l.Error("failed to confirm sns subscription", zap.Error(err))
+			http.Error(w, "failed to confirm subscription", http.StatusBadGateway)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			l.Error("failed to confirm sns subscription", zap.Int("status", resp.StatusCode))
+			http.Error(w, "failed to confirm subscription", http.StatusBadGateway)
+			return
+		}
+		l.Info("sns subscription confirmed")
+	case NotificationTypeUnsubscribeConfirmation:
+		l.Info("sns subscription removed")
+	case NotificationTypeNotification:
+		mc := newMContext(ctx, &Message{ID: n.ID, Body: string(b)}, EnvelopeSNS)
+		if err := s.handler.HandleMessage(mc.ctx, &mc.M); err != nil {
+			GetLogger(mc.ctx).Error("error handling message", zap.Error(err))
+			http.Error(w, "failed to handle notification", http.StatusInternalServerError)
+			return
+		}
+	default:
+		http.Error(w, "unknown notification type", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
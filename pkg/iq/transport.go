@@ -0,0 +1,83 @@
+package iq
+
+import (
+	"context"
+	goErrors "errors"
+)
+
+var (
+	ErrQueueDoesNotExist      = goErrors.New("queue does not exist")
+	ErrReceiptHandleIsInvalid = goErrors.New("receipt handle is invalid")
+)
+
+// Queue identifies a queue created through a Transport
+type Queue struct {
+	URL string
+	ARN string
+}
+
+// Message is a single message as returned by a Transport, before it is decoded into an M
+type Message struct {
+	ID                string
+	Body              string
+	ReceiptHandle     string
+	Attributes        map[string]string
+	MessageAttributes map[string]MessageAttribute
+}
+
+// ReceiveOptions mirror the long polling parameters of an SQS ReceiveMessage call. A zero VisibilityTimeout uses
+// the visibility timeout of the queue.
+type ReceiveOptions struct {
+	MaxNumberOfMessages int64
+	VisibilityTimeout   int64
+	WaitTimeSeconds     int64
+}
+
+// PublishInput is a single notification to publish to an SNS topic. GroupID and DeduplicationID only apply to FIFO
+// topics.
+type PublishInput struct {
+	TopicArn        string
+	Body            string
+	Attributes      map[string]MessageAttribute
+	GroupID         string
+	DeduplicationID string
+}
+
+// Subscription is an SNS subscription as listed by a Transport, Endpoint is the queue ARN for SQS subscriptions
+type Subscription struct {
+	ARN      string
+	TopicArn string
+	Endpoint string
+}
+
+// PublishResult is the outcome of one entry of a batch publish
+type PublishResult struct {
+	ID  string
+	Err error
+}
+
+// Transport is the set of queue and topic operations the iq workers depend on. The AWSTransport talks to
+// SQS/SNS, the MemoryTransport keeps everything in process so handlers can be exercised without AWS.
+type Transport interface {
+	CreateQueue(ctx context.Context, name string, attributes map[string]string, tags map[string]string) (*Queue, error)
+	// GetQueue looks up an existing queue by name, returning ErrQueueDoesNotExist when there is none
+	GetQueue(ctx context.Context, name string) (*Queue, error)
+	// ListQueues returns the URLs of every queue whose name starts with namePrefix
+	ListQueues(ctx context.Context, namePrefix string) ([]string, error)
+	GetQueueAttributes(ctx context.Context, qurl string) (map[string]string, error)
+	QueueTags(ctx context.Context, qurl string) (map[string]string, error)
+	SetQueueAttributes(ctx context.Context, qurl string, attributes map[string]string) error
+	DeleteQueue(ctx context.Context, qurl string) error
+	Subscribe(ctx context.Context, topicArn string, queueArn string, attributes map[string]string) (string, error)
+	Unsubscribe(ctx context.Context, subscriptionArn string) error
+	ListSubscriptions(ctx context.Context) ([]*Subscription, error)
+	GetSubscriptionAttributes(ctx context.Context, subscriptionArn string) (map[string]string, error)
+	SetSubscriptionAttributes(ctx context.Context, subscriptionArn string, name string, value string) error
+	ReceiveMessages(ctx context.Context, qurl string, opts ReceiveOptions) ([]*Message, error)
+	DeleteMessage(ctx context.Context, qurl string, receiptHandle string) error
+	ChangeMessageVisibility(ctx context.Context, qurl string, receiptHandle string, visibilityTimeout int64) error
+	SendMessage(ctx context.Context, qurl string, body string, attributes map[string]MessageAttribute) (string, error)
+	Publish(ctx context.Context, in *PublishInput) (string, error)
+	// PublishBatch publishes up to 10 entries to the same topic, failures are reported per entry
+	PublishBatch(ctx context.Context, topicArn string, in []*PublishInput) ([]PublishResult, error)
+}
@@ -0,0 +1,337 @@
+package iq
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+// AWSTransport implements Transport on top of SQS and SNS
+type AWSTransport struct {
+	sns *sns.SNS
+	sqs *sqs.SQS
+}
+
+func NewAWSTransport(sess *session.Session) *AWSTransport {
+	return &AWSTransport{
+		sqs: sqs.New(sess),
+		sns: sns.New(sess),
+	}
+}
+
+func (t *AWSTransport) CreateQueue(ctx context.Context, name string, attributes map[string]string, tags map[string]string) (*Queue, error) {
+	result, err := t.sqs.CreateQueueWithContext(ctx, &sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: aws.StringMap(attributes),
+		Tags:       aws.StringMap(tags),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	qAttrs, err := t.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		AttributeNames: aws.StringSlice([]string{"QueueArn"}),
+		QueueUrl:       result.QueueUrl,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &Queue{
+		URL: *result.QueueUrl,
+		ARN: *qAttrs.Attributes["QueueArn"],
+	}, nil
+}
+
+func (t *AWSTransport) GetQueue(ctx context.Context, name string) (*Queue, error) {
+	result, err := t.sqs.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(name),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == sqs.ErrCodeQueueDoesNotExist {
+			return nil, errors.Wrap(ErrQueueDoesNotExist, name)
+		}
+		return nil, errors.Wrap(err)
+	}
+
+	qAttrs, err := t.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		AttributeNames: aws.StringSlice([]string{"QueueArn"}),
+		QueueUrl:       result.QueueUrl,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	return &Queue{
+		URL: *result.QueueUrl,
+		ARN: *qAttrs.Attributes["QueueArn"],
+	}, nil
+}
+
+func (t *AWSTransport) ListQueues(ctx context.Context, namePrefix string) ([]string, error) {
+	var qurls []string
+	err := t.sqs.ListQueuesPagesWithContext(ctx, &sqs.ListQueuesInput{
+		QueueNamePrefix: aws.String(namePrefix),
+	}, func(page *sqs.ListQueuesOutput, lastPage bool) bool {
+		qurls = append(qurls, aws.StringValueSlice(page.QueueUrls)...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return qurls, nil
+}
+
+func (t *AWSTransport) GetQueueAttributes(ctx context.Context, qurl string) (map[string]string, error) {
+	result, err := t.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		AttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		QueueUrl:       aws.String(qurl),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return aws.StringValueMap(result.Attributes), nil
+}
+
+func (t *AWSTransport) QueueTags(ctx context.Context, qurl string) (map[string]string, error) {
+	result, err := t.sqs.ListQueueTagsWithContext(ctx, &sqs.ListQueueTagsInput{
+		QueueUrl: aws.String(qurl),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return aws.StringValueMap(result.Tags), nil
+}
+
+func (t *AWSTransport) SetQueueAttributes(ctx context.Context, qurl string, attributes map[string]string) error {
+	_, err := t.sqs.SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
+		Attributes: aws.StringMap(attributes),
+		QueueUrl:   aws.String(qurl),
+	})
+	return errors.Wrap(err)
+}
+
+func (t *AWSTransport) DeleteQueue(ctx context.Context, qurl string) error {
+	_, err := t.sqs.DeleteQueueWithContext(ctx, &sqs.DeleteQueueInput{
+		QueueUrl: aws.String(qurl),
+	})
+	return errors.Wrap(err)
+}
+
+func (t *AWSTransport) Subscribe(ctx context.Context, topicArn string, queueArn string, attributes map[string]string) (string, error) {
+	input := sns.SubscribeInput{
+		Endpoint: aws.String(queueArn),
+		Protocol: aws.String("sqs"),
+		TopicArn: aws.String(topicArn),
+	}
+	if len(attributes) > 0 {
+		input.Attributes = aws.StringMap(attributes)
+	}
+	r, err := t.sns.SubscribeWithContext(ctx, &input)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+	return *r.SubscriptionArn, nil
+}
+
+func (t *AWSTransport) Unsubscribe(ctx context.Context, subscriptionArn string) error {
+	_, err := t.sns.UnsubscribeWithContext(ctx, &sns.UnsubscribeInput{
+		SubscriptionArn: aws.String(subscriptionArn),
+	})
+	return errors.Wrap(err)
+}
+
+func (t *AWSTransport) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	var subscriptions []*Subscription
+	err := t.sns.ListSubscriptionsPagesWithContext(ctx, &sns.ListSubscriptionsInput{},
+		func(page *sns.ListSubscriptionsOutput, lastPage bool) bool {
+			for _, x := range page.Subscriptions {
+				subscriptions = append(subscriptions, &Subscription{
+					ARN:      aws.StringValue(x.SubscriptionArn),
+					TopicArn: aws.StringValue(x.TopicArn),
+					Endpoint: aws.StringValue(x.Endpoint),
+				})
+			}
+			return true
+		})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return subscriptions, nil
+}
+
+func (t *AWSTransport) GetSubscriptionAttributes(ctx context.Context, subscriptionArn string) (map[string]string, error) {
+	result, err := t.sns.GetSubscriptionAttributesWithContext(ctx, &sns.GetSubscriptionAttributesInput{
+		SubscriptionArn: aws.String(subscriptionArn),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+	return aws.StringValueMap(result.Attributes), nil
+}
+
+func (t *AWSTransport) SetSubscriptionAttributes(ctx context.Context, subscriptionArn string, name string, value string) error {
+	_, err := t.sns.SetSubscriptionAttributesWithContext(ctx, &sns.SetSubscriptionAttributesInput{
+		SubscriptionArn: aws.String(subscriptionArn),
+		AttributeName:   aws.String(name),
+		AttributeValue:  aws.String(value),
+	})
+	return errors.Wrap(err)
+}
+
+func (t *AWSTransport) ReceiveMessages(ctx context.Context, qurl string, opts ReceiveOptions) ([]*Message, error) {
+	input := sqs.ReceiveMessageInput{
+		AttributeNames: []*string{
+			aws.String(sqs.QueueAttributeNameAll),
+		},
+		MessageAttributeNames: []*string{
+			aws.String(sqs.QueueAttributeNameAll),
+		},
+		QueueUrl:            aws.String(qurl),
+		MaxNumberOfMessages: aws.Int64(opts.MaxNumberOfMessages),
+		WaitTimeSeconds:     aws.Int64(opts.WaitTimeSeconds),
+	}
+	if opts.VisibilityTimeout > 0 {
+		input.VisibilityTimeout = aws.Int64(opts.VisibilityTimeout)
+	}
+	result, err := t.sqs.ReceiveMessageWithContext(ctx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []*Message
+	for _, x := range result.Messages {
+		m := Message{
+			ID:                aws.StringValue(x.MessageId),
+			Body:              aws.StringValue(x.Body),
+			ReceiptHandle:     aws.StringValue(x.ReceiptHandle),
+			Attributes:        aws.StringValueMap(x.Attributes),
+			MessageAttributes: make(map[string]MessageAttribute),
+		}
+		for k, v := range x.MessageAttributes {
+			m.MessageAttributes[k] = MessageAttribute{
+				Type:  aws.StringValue(v.DataType),
+				Value: aws.StringValue(v.StringValue),
+			}
+		}
+		messages = append(messages, &m)
+	}
+
+	return messages, nil
+}
+
+func (t *AWSTransport) DeleteMessage(ctx context.Context, qurl string, receiptHandle string) error {
+	_, err := t.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(qurl),
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+	return errors.Wrap(err)
+}
+
+func (t *AWSTransport) ChangeMessageVisibility(ctx context.Context, qurl string, receiptHandle string, visibilityTimeout int64) error {
+	_, err := t.sqs.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(qurl),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: aws.Int64(visibilityTimeout),
+	})
+	return errors.Wrap(err)
+}
+
+func (t *AWSTransport) SendMessage(ctx context.Context, qurl string, body string, attributes map[string]MessageAttribute) (string, error) {
+	input := sqs.SendMessageInput{
+		QueueUrl:    aws.String(qurl),
+		MessageBody: aws.String(body),
+	}
+	if len(attributes) > 0 {
+		input.MessageAttributes = make(map[string]*sqs.MessageAttributeValue)
+		for k, v := range attributes {
+			input.MessageAttributes[k] = &sqs.MessageAttributeValue{
+				DataType:    aws.String(v.Type),
+				StringValue: aws.String(v.Value),
+			}
+		}
+	}
+
+	r, err := t.sqs.SendMessageWithContext(ctx, &input)
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+	return *r.MessageId, nil
+}
+
+func snsAttributes(attributes map[string]MessageAttribute) map[string]*sns.MessageAttributeValue {
+	if len(attributes) == 0 {
+		return nil
+	}
+	values := make(map[string]*sns.MessageAttributeValue)
+	for k, v := range attributes {
+		values[k] = &sns.MessageAttributeValue{
+			DataType:    aws.String(v.Type),
+			StringValue: aws.String(v.Value),
+		}
+	}
+	return values
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
+func (t *AWSTransport) Publish(ctx context.Context, in *PublishInput) (string, error) {
+	r, err := t.sns.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn:               aws.String(in.TopicArn),
+		Message:                aws.String(in.Body),
+		MessageAttributes:      snsAttributes(in.Attributes),
+		MessageGroupId:         optionalString(in.GroupID),
+		MessageDeduplicationId: optionalString(in.DeduplicationID),
+	})
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+	return aws.StringValue(r.MessageId), nil
+}
+
+func (t *AWSTransport) PublishBatch(ctx context.Context, topicArn string, in []*PublishInput) ([]PublishResult, error) {
+	entries := make([]*sns.PublishBatchRequestEntry, len(in))
+	for i, x := range in {
+		entries[i] = &sns.PublishBatchRequestEntry{
+			Id:                     aws.String(fmt.Sprint(i)),
+			Message:                aws.String(x.Body),
+			MessageAttributes:      snsAttributes(x.Attributes),
+			MessageGroupId:         optionalString(x.GroupID),
+			MessageDeduplicationId: optionalString(x.DeduplicationID),
+		}
+	}
+
+	r, err := t.sns.PublishBatchWithContext(ctx, &sns.PublishBatchInput{
+		TopicArn:                   aws.String(topicArn),
+		PublishBatchRequestEntries: entries,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err)
+	}
+
+	results := make([]PublishResult, len(in))
+	for _, x := range r.Successful {
+		if i, err := strconv.Atoi(aws.StringValue(x.Id)); err == nil && i < len(results) {
+			results[i].ID = aws.StringValue(x.MessageId)
+		}
+	}
+	for _, x := range r.Failed {
+		if i, err := strconv.Atoi(aws.StringValue(x.Id)); err == nil && i < len(results) {
+			results[i].Err = errors.Wrapf("%s: %s", aws.StringValue(x.Code), aws.StringValue(x.Message))
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,561 @@
+package iq
+
+import (
+	"context"
+	gjson "encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+const (
+	memoryAccountID = "000000000000"
+	memoryRegion    = "local"
+)
+
+type memoryMessage struct {
+	id                string
+	body              string
+	messageAttributes map[string]MessageAttribute
+	sentAt            time.Time
+	visibleAt         time.Time
+	receiptHandle     string
+	receiveCount      int
+}
+
+type memoryQueue struct {
+	name       string
+	url        string
+	arn        string
+	attributes map[string]string
+	tags       map[string]string
+	messages   []*memoryMessage
+	// signal is closed and replaced whenever a message is added so long polling receivers wake up
+	signal chan struct{}
+}
+
+type memorySubscription struct {
+	arn        string
+	topicArn   string
+	queueArn   string
+	attributes map[string]string
+}
+
+// MemoryTransport is an in process Transport intended for tests and local development. It honors delivery delays,
+// visibility timeouts, retention periods and receipt handles, and fans out messages published to a topic to every
+// subscribed queue whose filter policy matches, wrapped in an SNS notification envelope.
+type MemoryTransport struct {
+	mu            sync.Mutex
+	queues        map[string]*memoryQueue
+	subscriptions map[string]*memorySubscription
+	deduplication map[string]memoryDeduplication
+}
+
+type memoryDeduplication struct {
+	id string
+	at time.Time
+}
+
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		queues:        make(map[string]*memoryQueue),
+		subscriptions: make(map[string]*memorySubscription),
+		deduplication: make(map[string]memoryDeduplication),
+	}
+}
+
+// TopicArn returns the ARN the MemoryTransport uses for a topic name
+func (t *MemoryTransport) TopicArn(name string) string {
+	return fmt.Sprintf("arn:aws:sns:%s:%s:%s", memoryRegion, memoryAccountID, name)
+}
+
+func (t *MemoryTransport) CreateQueue(ctx context.Context, name string, attributes map[string]string, tags map[string]string) (*Queue, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	url := fmt.Sprintf("memory://sqs/%s/%s", memoryAccountID, name)
+	if q, ok := t.queues[url]; ok {
+		return &Queue{URL: q.url, ARN: q.arn}, nil
+	}
+
+	q := &memoryQueue{
+		name:       name,
+		url:        url,
+		arn:        fmt.Sprintf("arn:aws:sqs:%s:%s:%s", memoryRegion, memoryAccountID, name),
+		attributes: copyStringMap(attributes),
+		tags:       copyStringMap(tags),
+		signal:     make(chan struct{}),
+	}
+	t.queues[url] = q
+
+	return &Queue{URL: q.url, ARN: q.arn}, nil
+}
+
+func (t *MemoryTransport) GetQueue(ctx context.Context, name string) (*Queue, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[fmt.Sprintf("memory://sqs/%s/%s", memoryAccountID, name)]
+	if !ok {
+		return nil, errors.Wrap(ErrQueueDoesNotExist, name)
+	}
+	return &Queue{URL: q.url, ARN: q.arn}, nil
+}
+
+func (t *MemoryTransport) ListQueues(ctx context.Context, namePrefix string) ([]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var qurls []string
+	for _, q := range t.queues {
+		if strings.HasPrefix(q.name, namePrefix) {
+			qurls = append(qurls, q.url)
+		}
+	}
+	sort.Strings(qurls)
+	return qurls, nil
+}
+
+func (t *MemoryTransport) GetQueueAttributes(ctx context.Context, qurl string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[qurl]
+	if !ok {
+		return nil, errors.Wrap(ErrQueueDoesNotExist, qurl)
+	}
+	attributes := copyStringMap(q.attributes)
+	attributes["QueueArn"] = q.arn
+	return attributes, nil
+}
+
+func (t *MemoryTransport) QueueTags(ctx context.Context, qurl string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[qurl]
+	if !ok {
+		return nil, errors.Wrap(ErrQueueDoesNotExist, qurl)
+	}
+	return copyStringMap(q.tags), nil
+}
+
+func (t *MemoryTransport) SetQueueAttributes(ctx context.Context, qurl string, attributes map[string]string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[qurl]
+	if !ok {
+		return errors.Wrap(ErrQueueDoesNotExist, qurl)
+	}
+	for k, v := range attributes {
+		q.attributes[k] = v
+	}
+	return nil
+}
+
+func (t *MemoryTransport) DeleteQueue(ctx context.Context, qurl string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[qurl]
+	if !ok {
+		return errors.Wrap(ErrQueueDoesNotExist, qurl)
+	}
+	delete(t.queues, qurl)
+	close(q.signal)
+	return nil
+}
+
+func (t *MemoryTransport) Subscribe(ctx context.Context, topicArn string, queueArn string, attributes map[string]string) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range t.subscriptions {
+		if s.topicArn == topicArn && s.queueArn == queueArn {
+			for k, v := range attributes {
+				s.attributes[k] = v
+			}
+			return s.arn, nil
+		}
+	}
+
+	s := &memorySubscription{
+		arn:        fmt.Sprintf("%s:%s", topicArn, uuid.NewV4().String()),
+		topicArn:   topicArn,
+		queueArn:   queueArn,
+		attributes: copyStringMap(attributes),
+	}
+	t.subscriptions[s.arn] = s
+	return s.arn, nil
+}
+
+func (t *MemoryTransport) Unsubscribe(ctx context.Context, subscriptionArn string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.subscriptions, subscriptionArn)
+	return nil
+}
+
+func (t *MemoryTransport) ListSubscriptions(ctx context.Context) ([]*Subscription, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var subscriptions []*Subscription
+	for _, x := range t.subscriptions {
+		subscriptions = append(subscriptions, &Subscription{
+			ARN:      x.arn,
+			TopicArn: x.topicArn,
+			Endpoint: x.queueArn,
+		})
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		return subscriptions[i].ARN < subscriptions[j].ARN
+	})
+	return subscriptions, nil
+}
+
+func (t *MemoryTransport) GetSubscriptionAttributes(ctx context.Context, subscriptionArn string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.subscriptions[subscriptionArn]
+	if !ok {
+		return nil, errors.Wrapf("subscription %s does not exist", subscriptionArn)
+	}
+	attributes := copyStringMap(s.attributes)
+	attributes["SubscriptionArn"] = s.arn
+	attributes["TopicArn"] = s.topicArn
+	attributes["Endpoint"] = s.queueArn
+	return attributes, nil
+}
+
+func (t *MemoryTransport) SetSubscriptionAttributes(ctx context.Context, subscriptionArn string, name string, value string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s, ok := t.subscriptions[subscriptionArn]
+	if !ok {
+		return errors.Wrapf("subscription %s does not exist", subscriptionArn)
+	}
+	s.attributes[name] = value
+	return nil
+}
+
+// Publish wraps the body in an SNS notification envelope and delivers it to every queue subscribed to the topic.
+// FIFO topics drop entries whose DeduplicationID was already published in the last 5 minutes.
+func (t *MemoryTransport) Publish(ctx context.Context, in *PublishInput) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.publish(in)
+}
+
+func (t *MemoryTransport) PublishBatch(ctx context.Context, topicArn string, in []*PublishInput) ([]PublishResult, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	results := make([]PublishResult, len(in))
+	for i, x := range in {
+		entry := *x
+		entry.TopicArn = topicArn
+		results[i].ID, results[i].Err = t.publish(&entry)
+	}
+	return results, nil
+}
+
+func (t *MemoryTransport) publish(in *PublishInput) (string, error) {
+	now := time.Now()
+	var deduplicationKey string
+	if strings.HasSuffix(in.TopicArn, ".fifo") {
+		if in.GroupID == "" {
+			return "", errors.Wrapf("MessageGroupId is required for FIFO topic %s", in.TopicArn)
+		}
+		if in.DeduplicationID != "" {
+			deduplicationKey = in.TopicArn + "/" + in.DeduplicationID
+			if d, ok := t.deduplication[deduplicationKey]; ok && now.Sub(d.at) < 5*time.Minute {
+				return d.id, nil
+			}
+		}
+	}
+
+	id := uuid.NewV4().String()
+	b, err := gjson.Marshal(NotificationMessage{
+		Type:             "Notification",
+		ID:               id,
+		TopicArn:         in.TopicArn,
+		Body:             in.Body,
+		Timestamp:        now.UTC(),
+		SignatureVersion: "1",
+		Attributes:       in.Attributes,
+	})
+	if err != nil {
+		return "", errors.Wrap(err)
+	}
+
+	for _, s := range t.subscriptions {
+		if s.topicArn != in.TopicArn {
+			continue
+		}
+		q := t.queueByArn(s.queueArn)
+		if q == nil {
+			continue
+		}
+		if !matchFilterPolicy(s.attributes["FilterPolicy"], s.attributes["FilterPolicyScope"], in.Body, in.Attributes) {
+			continue
+		}
+		if s.attributes["RawMessageDelivery"] == "true" {
+			q.add(in.Body, in.Attributes)
+		} else {
+			q.add(string(b), nil)
+		}
+	}
+
+	if deduplicationKey != "" {
+		t.deduplication[deduplicationKey] = memoryDeduplication{id: id, at: now}
+	}
+	return id, nil
+}
+
+// SendMessage delivers body directly to the queue, bypassing any topic
+func (t *MemoryTransport) SendMessage(ctx context.Context, qurl string, body string, attributes map[string]MessageAttribute) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[qurl]
+	if !ok {
+		return "", errors.Wrap(ErrQueueDoesNotExist, qurl)
+	}
+	return q.add(body, attributes), nil
+}
+
+func (t *MemoryTransport) ReceiveMessages(ctx context.Context, qurl string, opts ReceiveOptions) ([]*Message, error) {
+	deadline := time.Now().Add(time.Duration(opts.WaitTimeSeconds) * time.Second)
+	for {
+		t.mu.Lock()
+		q, ok := t.queues[qurl]
+		if !ok {
+			t.mu.Unlock()
+			return nil, errors.Wrap(ErrQueueDoesNotExist, qurl)
+		}
+		now := time.Now()
+		t.redrive(q, now)
+		messages := q.receive(now, opts)
+		signal := q.signal
+		wake := deadline
+		if next, ok := q.nextVisible(now); ok && next.Before(wake) {
+			wake = next
+		}
+		t.mu.Unlock()
+
+		if len(messages) > 0 || !now.Before(deadline) {
+			return messages, nil
+		}
+
+		timer := time.NewTimer(wake.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-signal:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (t *MemoryTransport) DeleteMessage(ctx context.Context, qurl string, receiptHandle string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[qurl]
+	if !ok {
+		return errors.Wrap(ErrQueueDoesNotExist, qurl)
+	}
+	for i, m := range q.messages {
+		if m.receiptHandle == receiptHandle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return nil
+		}
+	}
+	return errors.Wrap(ErrReceiptHandleIsInvalid, receiptHandle)
+}
+
+func (t *MemoryTransport) ChangeMessageVisibility(ctx context.Context, qurl string, receiptHandle string, visibilityTimeout int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.queues[qurl]
+	if !ok {
+		return errors.Wrap(ErrQueueDoesNotExist, qurl)
+	}
+	for _, m := range q.messages {
+		if m.receiptHandle == receiptHandle {
+			m.visibleAt = time.Now().Add(time.Duration(visibilityTimeout) * time.Second)
+			q.notify()
+			return nil
+		}
+	}
+	return errors.Wrap(ErrReceiptHandleIsInvalid, receiptHandle)
+}
+
+// Messages returns the number of messages, visible or not, currently held by the queue
+func (t *MemoryTransport) Messages(qurl string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if q, ok := t.queues[qurl]; ok {
+		return len(q.messages)
+	}
+	return 0
+}
+
+func (t *MemoryTransport) queueByArn(arn string) *memoryQueue {
+	for _, q := range t.queues {
+		if q.arn == arn {
+			return q
+		}
+	}
+	return nil
+}
+
+// redrive moves visible messages that exhausted the maxReceiveCount of the queue's RedrivePolicy to its
+// dead-letter queue
+func (t *MemoryTransport) redrive(q *memoryQueue, now time.Time) {
+	policy, ok := q.attributes["RedrivePolicy"]
+	if !ok {
+		return
+	}
+	var rp RedrivePolicy
+	if err := gjson.Unmarshal([]byte(policy), &rp); err != nil {
+		return
+	}
+	maxReceiveCount, err := strconv.Atoi(rp.MaxReceiveCount)
+	if err != nil || maxReceiveCount <= 0 {
+		return
+	}
+	dlq := t.queueByArn(rp.DeadLetterTargetArn)
+	if dlq == nil {
+		return
+	}
+
+	kept := q.messages[:0]
+	for _, m := range q.messages {
+		if m.receiveCount >= maxReceiveCount && !m.visibleAt.After(now) {
+			dlq.messages = append(dlq.messages, &memoryMessage{
+				id:                m.id,
+				body:              m.body,
+				messageAttributes: m.messageAttributes,
+				sentAt:            m.sentAt,
+				visibleAt:         now,
+			})
+			continue
+		}
+		kept = append(kept, m)
+	}
+	q.messages = kept
+}
+
+func (q *memoryQueue) intAttribute(name string) int64 {
+	v, err := strconv.ParseInt(q.attributes[name], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+func (q *memoryQueue) add(body string, attributes map[string]MessageAttribute) string {
+	now := time.Now()
+	m := &memoryMessage{
+		id:                uuid.NewV4().String(),
+		body:              body,
+		messageAttributes: attributes,
+		sentAt:            now,
+		visibleAt:         now.Add(time.Duration(q.intAttribute("DelaySeconds")) * time.Second),
+	}
+	q.messages = append(q.messages, m)
+	q.notify()
+	return m.id
+}
+
+func (q *memoryQueue) notify() {
+	close(q.signal)
+	q.signal = make(chan struct{})
+}
+
+func (q *memoryQueue) expire(now time.Time) {
+	retention := q.intAttribute("MessageRetentionPeriod")
+	if retention <= 0 {
+		return
+	}
+	kept := q.messages[:0]
+	for _, m := range q.messages {
+		if now.Sub(m.sentAt) < time.Duration(retention)*time.Second {
+			kept = append(kept, m)
+		}
+	}
+	q.messages = kept
+}
+
+func (q *memoryQueue) receive(now time.Time, opts ReceiveOptions) []*Message {
+	q.expire(now)
+
+	max := opts.MaxNumberOfMessages
+	if max <= 0 {
+		max = 1
+	}
+	visibility := opts.VisibilityTimeout
+	if visibility <= 0 {
+		visibility = q.intAttribute("VisibilityTimeout")
+	}
+
+	var messages []*Message
+	for _, m := range q.messages {
+		if int64(len(messages)) >= max {
+			break
+		}
+		if m.visibleAt.After(now) {
+			continue
+		}
+		m.receiveCount++
+		m.receiptHandle = uuid.NewV4().String()
+		m.visibleAt = now.Add(time.Duration(visibility) * time.Second)
+		messages = append(messages, &Message{
+			ID:            m.id,
+			Body:          m.body,
+			ReceiptHandle: m.receiptHandle,
+			Attributes: map[string]string{
+				"ApproximateReceiveCount": strconv.Itoa(m.receiveCount),
+				"SentTimestamp":           strconv.FormatInt(m.sentAt.UnixNano()/int64(time.Millisecond), 10),
+			},
+			MessageAttributes: m.messageAttributes,
+		})
+	}
+	return messages
+}
+
+func (q *memoryQueue) nextVisible(now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, m := range q.messages {
+		if m.visibleAt.After(now) && (next.IsZero() || m.visibleAt.Before(next)) {
+			next = m.visibleAt
+		}
+	}
+	return next, !next.IsZero()
+}
+
+func copyStringMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,101 @@
+package iq
+
+import (
+	"context"
+	goErrors "errors"
+	"math"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+var (
+	ErrNotInFlight     = goErrors.New("context does not belong to an in-flight message")
+	ErrMessageReleased = goErrors.New("message has already been released")
+)
+
+type ctxKeyInflight int
+
+const inflightKey ctxKeyInflight = 0
+
+// inflight tracks a message whose handler is still running
+type inflight struct {
+	q        *consumer
+	m        *mContext
+	cancel   context.CancelFunc
+	released bool
+}
+
+func (f *inflight) changeVisibility(ctx context.Context, seconds int64) error {
+	f.q.mu.Lock()
+	released := f.released
+	f.q.mu.Unlock()
+	if released {
+		return ErrMessageReleased
+	}
+
+	return errors.Wrap(f.q.transport.ChangeMessageVisibility(ctx, f.q.qurl, f.m.ReceiptHandle, seconds))
+}
+
+// heartbeat extends the visibility of the message by window seconds at half the window until ctx is done
+func (f *inflight) heartbeat(ctx context.Context, window int64) {
+	ticker := time.NewTicker(time.Duration(window) * time.Second / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := f.changeVisibility(ctx, window)
+			if goErrors.Is(err, ErrMessageReleased) {
+				return
+			}
+			if err != nil {
+				f.q.logWith(ctx).Error("error extending message visibility", zap.Error(err), zap.String("id", f.m.ID))
+				continue
+			}
+			f.q.logWith(ctx).Debug("message visibility extended", zap.String("id", f.m.ID), zap.Int64("seconds", window))
+		}
+	}
+}
+
+func inflightFrom(ctx context.Context) (*inflight, error) {
+	if ctx == nil {
+		return nil, ErrNotInFlight
+	}
+	if f, ok := ctx.Value(inflightKey).(*inflight); ok {
+		return f, nil
+	}
+	return nil, ErrNotInFlight
+}
+
+// ExtendVisibility hides the message being handled with ctx from other receivers for another d, counted from now.
+// It can be called from a Handler that knows it needs more time than the configured visibility timeout.
+func ExtendVisibility(ctx context.Context, d time.Duration) error {
+	f, err := inflightFrom(ctx)
+	if err != nil {
+		return err
+	}
+	return f.changeVisibility(ctx, int64(math.Ceil(d.Seconds())))
+}
+
+// Release makes the message being handled with ctx visible again immediately, so it is redelivered instead of
+// being deleted when the Handler returns
+func Release(ctx context.Context) error {
+	f, err := inflightFrom(ctx)
+	if err != nil {
+		return err
+	}
+	if err := f.changeVisibility(ctx, 0); err != nil {
+		return err
+	}
+
+	f.q.mu.Lock()
+	f.released = true
+	f.q.mu.Unlock()
+
+	f.q.logWith(ctx).Info("notification message released", zap.String("id", f.m.ID))
+	return nil
+}
@@ -2,19 +2,12 @@ package iq
 
 import (
 	"context"
-	gjson "encoding/json"
 	"fmt"
 	"strings"
-	"sync"
-	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sns"
-	"github.com/aws/aws-sdk-go/service/sqs"
 	"go.uber.org/zap"
 
-	"github.com/unanet/go/v2/pkg/errors"
 	"github.com/unanet/go/v2/pkg/log"
 )
 
@@ -43,33 +36,60 @@ type Config struct {
 	DeliveryDelay          int64    `split_words:"true" default:"0"`
 	MessageRetentionPeriod int64    `split_words:"true" default:"3600"`
 	HandlerTimeout         int64    `split_words:"true" default:"60"`
-}
-
+	// MaxInFlight caps the number of messages handled concurrently, defaults to MaxNumberOfMessages
+	MaxInFlight int64 `split_words:"true" default:"10"`
+	// VisibilityExtension enables a heartbeat that keeps extending the visibility of a message by this many seconds
+	// while its handler is running, so VisibilityTimeout no longer has to cover the slowest handler
+	VisibilityExtension int64 `split_words:"true" default:"0"`
+	// Envelope selects how message bodies are decoded, one of EnvelopeSNS, EnvelopeRaw, EnvelopeEventBridge or
+	// EnvelopeAuto to detect the format of every message
+	Envelope string `split_words:"true" default:"auto"`
+	// RawMessageDelivery subscribes the queue to the topics with SNS raw message delivery enabled
+	RawMessageDelivery bool `split_words:"true" default:"false"`
+	// FilterPolicies sets the SNS filter policy of the subscription to each topic, subscriptions are updated when the
+	// policy changes between deploys
+	FilterPolicies FilterPolicies `split_words:"true"`
+	// FailureThreshold is the number of consecutive receive errors after which the worker reports itself as failed
+	FailureThreshold int64 `split_words:"true" default:"5"`
+	// ReceiveMaxBackoff caps the delay in seconds between retries of failed receives, defaults to 30
+	ReceiveMaxBackoff int64 `split_words:"true" default:"30"`
+	// VerifySignatures checks the SNS signature of every notification with a default SignatureVerifier
+	VerifySignatures bool `split_words:"true" default:"false"`
+
+	// DeadLetterQueue creates a companion "<queue>_dlq" queue that messages are moved to once they have been
+	// received MaxReceiveCount times without being deleted
+	DeadLetterQueue           bool  `split_words:"true" default:"false"`
+	MaxReceiveCount           int64 `split_words:"true" default:"5"`
+	DeadLetterRetentionPeriod int64 `split_words:"true" default:"1209600"`
+}
+
+// InstanceQ consumes a queue of its own that is subscribed to the configured topics, so every instance of a
+// service sees every message. The queue and its subscriptions are deleted when the InstanceQ is stopped.
 type InstanceQ struct {
-	log           *zap.Logger
-	name          string
-	ctx           context.Context
-	cancel        context.CancelFunc
-	done          chan bool
-	sns           *sns.SNS
-	sqs           *sqs.SQS
-	c             *Config
-	qurl          string
-	qarn          string
-	subscriptions []string
+	*consumer
 }
 
-func NewInstanceQ(instanceName string, sess *session.Session, c *Config) (*InstanceQ, error) {
-	w := InstanceQ{
-		name: instanceName,
-		log:  log.Logger.With(zap.String("worker", instanceName)),
-		c:    c,
-		sqs:  sqs.New(sess),
-		sns:  sns.New(sess),
-		done: make(chan bool),
+// Option configures an InstanceQ or WorkQ beyond what Config covers
+type Option func(*consumer)
+
+// WithSignatureVerifier verifies the SNS signature of every notification before it is handled, messages that fail
+// verification are treated like a failed Handler
+func WithSignatureVerifier(v *SignatureVerifier) Option {
+	return func(q *consumer) {
+		q.verifier = v
 	}
+}
 
-	return &w, nil
+func NewInstanceQ(instanceName string, sess *session.Session, c *Config, opts ...Option) (*InstanceQ, error) {
+	return NewInstanceQWithTransport(instanceName, NewAWSTransport(sess), c, opts...)
+}
+
+// NewInstanceQWithTransport creates an InstanceQ that uses t for every queue and topic operation, e.g. a
+// MemoryTransport to run handlers without AWS
+func NewInstanceQWithTransport(instanceName string, t Transport, c *Config, opts ...Option) (*InstanceQ, error) {
+	return &InstanceQ{
+		consumer: newConsumer("instance queue", instanceName, t, c, opts),
+	}, nil
 }
 
 func getInstanceID(instanceName string) string {
@@ -82,82 +102,10 @@ func getInstanceID(instanceName string) string {
 }
 
 func (q *InstanceQ) createQ(ctx context.Context) error {
-	instanceID := getInstanceID(q.name)
-
-	result, err := q.sqs.CreateQueueWithContext(ctx, &sqs.CreateQueueInput{
-		QueueName: aws.String(fmt.Sprintf("%s_srv-%s", q.c.Prefix, q.name)),
-		Attributes: map[string]*string{
-			"DelaySeconds":           aws.String(fmt.Sprint(q.c.DeliveryDelay)),
-			"VisibilityTimeout":      aws.String(fmt.Sprint(q.c.VisibilityTimeout)),
-			"MessageRetentionPeriod": aws.String(fmt.Sprint(q.c.MessageRetentionPeriod)),
-		},
-		Tags: map[string]*string{
-			"Prefix":     aws.String(q.c.Prefix),
-			"InstanceID": aws.String(instanceID),
-		},
-	})
-	if err != nil {
-		return err
-	}
-
-	qAttrs, err := q.sqs.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
-		AttributeNames: aws.StringSlice([]string{"QueueArn"}),
-		QueueUrl:       result.QueueUrl,
-	})
-	if err != nil {
-		return err
-	}
-
-	qarn := *qAttrs.Attributes["QueueArn"]
-
-	var subscriptions []string
-	var policies []interface{}
-
-	log.Logger.Debug("subscribe Q ARns", zap.String("qarn", qarn), zap.Strings("topic_arns", q.c.TopicArns))
-
-	for _, x := range q.c.TopicArns {
-		log.Logger.Debug("subscription to topic ARns", zap.String("arn", x))
-
-		r, err := q.sns.SubscribeWithContext(ctx, &sns.SubscribeInput{
-			Endpoint: aws.String(qarn),
-			Protocol: aws.String("sqs"),
-			TopicArn: aws.String(x),
-		})
-		if err != nil {
-			log.Logger.Error("failed to subscribe to topic", zap.String("topic", x), zap.String("iq", qarn), zap.Error(err))
-			return err
-		} else {
-			subscriptions = append(subscriptions, *r.SubscriptionArn)
-			policies = append(policies, getSqsPolicy(qarn, x))
-		}
-	}
-
-	log.Logger.Debug("queue policies", zap.Any("policies", policies))
-
-	b, err := gjson.Marshal(map[string]interface{}{
-		"Statement": policies,
-	})
-	if err != nil {
-		log.Logger.Error("failed to marshal sqs policies", zap.Error(err))
-	}
-
-	policy := string(b)
-
-	_, err = q.sqs.SetQueueAttributesWithContext(ctx, &sqs.SetQueueAttributesInput{
-		Attributes: map[string]*string{
-			"Policy": aws.String(policy),
-		},
-		QueueUrl: result.QueueUrl,
+	return q.createQueue(ctx, fmt.Sprintf("%s_srv-%s", q.c.Prefix, q.name), map[string]string{
+		"Prefix":     q.c.Prefix,
+		"InstanceID": getInstanceID(q.name),
 	})
-	if err != nil {
-		log.Logger.Error("failed to set sqs policy", zap.Error(err), zap.String("policy", policy))
-	}
-
-	q.subscriptions = subscriptions
-	q.qurl = *result.QueueUrl
-	q.qarn = qarn
-
-	return nil
 }
 
 func (q *InstanceQ) Start(ctx context.Context, h Handler) error {
@@ -165,160 +113,40 @@ func (q *InstanceQ) Start(ctx context.Context, h Handler) error {
 		return err
 	}
 
-	cctx, ccancel := context.WithCancel(context.Background())
-	q.ctx = cctx
-	q.cancel = ccancel
-
-	go func() {
-		q.log.Info("instance queue worker started")
-		for {
-			select {
-			case <-q.ctx.Done():
-				q.log.Info("instance queue worker stopped")
-				close(q.done)
-				return
-			default:
-				ctx := context.Background()
-				m, err := q.receive(ctx)
-				if err != nil {
-					q.log.Panic("error receiving message from queue", zap.Error(err))
-				}
-				if len(m) == 0 {
-					continue
-				}
-				q.run(h, m)
-			}
-		}
-	}()
-
+	q.start(h)
 	return nil
 }
 
-func (q *InstanceQ) cleanup() {
-	if q.sns != nil {
-		for _, x := range q.subscriptions {
-			q.log.Info("unsubscribing from SNS Topic", zap.String("subscription", x))
-			if _, err := q.sns.Unsubscribe(&sns.UnsubscribeInput{
-				SubscriptionArn: aws.String(x),
-			}); err != nil {
-				q.log.Error("error unsubscribing from SNS Topic", zap.Error(err), zap.String("subscription", x))
-			}
-		}
-	}
-
-	if q.sqs != nil {
-		q.log.Info("deleting SQS Queue", zap.String("name", q.qurl))
-		_, err := q.sqs.DeleteQueue(&sqs.DeleteQueueInput{
-			QueueUrl: aws.String(q.qurl),
-		})
-		if err != nil {
-			q.log.Error("error deleting SQS Topic", zap.Error(err), zap.String("qurl", q.qurl))
-		}
-	}
-}
-
-func (q *InstanceQ) Stop() {
-	q.cancel()
-	<-q.done
+// Stop stops receiving messages and waits for in-flight handlers to finish until ctx is done. Messages still being
+// handled at that point are released back to the queue by resetting their visibility timeout and their handler
+// context is cancelled. The queue is deleted either way, Stop returns ctx.Err() if the handlers did not drain in
+// time.
+func (q *InstanceQ) Stop(ctx context.Context) error {
+	err := q.stop(ctx)
 	q.cleanup()
+	return err
 }
 
-func (q *InstanceQ) run(h Handler, mCtx []*mContext) {
-	numMessages := len(mCtx)
-	var wg sync.WaitGroup
-	wg.Add(numMessages)
-	for _, mc := range mCtx {
-		go func(m *mContext) {
-			ctx, cancel := context.WithTimeout(m.ctx, time.Duration(q.c.HandlerTimeout)*time.Second)
-			defer cancel()
-			defer wg.Done()
-			if err := h.HandleMessage(ctx, &m.M); err != nil {
-				q.log.Error("error handling message", zap.Error(err))
-			} else {
-				err = q.deleteMessage(m.ctx, &m.M)
-				if err != nil {
-					q.log.Error("error deleting message", zap.Error(err))
-				}
-			}
-		}(mc)
-	}
-	wg.Wait()
-}
-
-func (q *InstanceQ) logWith(ctx context.Context) *zap.Logger {
-	return q.log.With(zap.String("req_id", log.GetReqID(ctx)))
-}
-
-func (q *InstanceQ) receive(ctx context.Context) ([]*mContext, error) {
-	awsM := sqs.ReceiveMessageInput{
-		MessageAttributeNames: []*string{
-			aws.String(sqs.QueueAttributeNameAll),
-		},
-		QueueUrl:            aws.String(q.qurl),
-		MaxNumberOfMessages: aws.Int64(q.c.MaxNumberOfMessages),
-		VisibilityTimeout:   aws.Int64(q.c.VisibilityTimeout),
-		WaitTimeSeconds:     aws.Int64(q.c.WaitTimeSecond),
-	}
-	result, err := q.sqs.ReceiveMessage(&awsM)
-	if err != nil {
-		if strings.HasPrefix(err.Error(), "RequestCanceled") {
-			return nil, nil
+func (q *InstanceQ) cleanup() {
+	ctx := context.Background()
+	for _, x := range q.subscriptions {
+		q.log.Info("unsubscribing from SNS Topic", zap.String("subscription", x))
+		if err := q.transport.Unsubscribe(ctx, x); err != nil {
+			q.log.Error("error unsubscribing from SNS Topic", zap.Error(err), zap.String("subscription", x))
 		}
-		return nil, errors.Wrap(err)
 	}
 
-	var returnMs []*mContext
-	for _, x := range result.Messages {
-		var n NotificationMessage
-		err = gjson.Unmarshal([]byte(*x.Body), &n)
-		if err != nil {
-			log.Logger.Error("failed to unmarshal notification message", zap.Error(err))
-		}
-
-		body := make(map[string]interface{})
-		err = gjson.Unmarshal([]byte(n.Body), &body)
-		if err != nil {
-			log.Logger.Error("failed to unmarshal notification body", zap.Error(err))
-		}
-		m := M{
-			ID:            *x.MessageId,
-			Notification:  n,
-			Body:          body,
-			RawBody:       []byte(n.Body),
-			ReceiptHandle: *x.ReceiptHandle,
-		}
-		var mctx context.Context
-		if val, ok := n.Attributes[MessageAttributeReqID]; ok {
-			mctx = context.WithValue(ctx, log.RequestIDKey, val.Value)
-		} else {
-			mctx = context.WithValue(ctx, log.RequestIDKey, "00000000000000000000000000000000")
-		}
-		returnMs = append(returnMs, &mContext{
-			M:   m,
-			ctx: mctx,
-		})
-		q.logWith(mctx).Info("notification message received",
-			zap.Any("id", m.ID),
-		)
+	q.log.Info("deleting SQS Queue", zap.String("name", q.qurl))
+	if err := q.transport.DeleteQueue(ctx, q.qurl); err != nil {
+		q.log.Error("error deleting SQS Topic", zap.Error(err), zap.String("qurl", q.qurl))
 	}
 
-	return returnMs, nil
-}
-
-func (q *InstanceQ) deleteMessage(ctx context.Context, m *M) error {
-	now := time.Now()
-	_, err := q.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(q.qurl),
-		ReceiptHandle: aws.String(m.ReceiptHandle),
-	})
-	if err != nil {
-		return errors.Wrap(err)
+	if q.dlqurl != "" {
+		q.log.Info("deleting SQS Dead Letter Queue", zap.String("name", q.dlqurl))
+		if err := q.transport.DeleteQueue(ctx, q.dlqurl); err != nil {
+			q.log.Error("error deleting SQS Dead Letter Queue", zap.Error(err), zap.String("qurl", q.dlqurl))
+		}
 	}
-	q.logWith(ctx).Info("notification message deleted",
-		zap.Float64("elapsed_ms", float64(time.Since(now).Nanoseconds())/1000000.0),
-		zap.Any("id", m.ID),
-	)
-	return nil
 }
 
 func GetLogger(ctx context.Context) *zap.Logger {
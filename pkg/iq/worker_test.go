@@ -0,0 +1,340 @@
+package iq
+
+import (
+	"context"
+	goErrors "errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(topicArns ...string) *Config {
+	return &Config{
+		Prefix:                 "test",
+		TopicArns:              topicArns,
+		MaxNumberOfMessages:    10,
+		WaitTimeSecond:         1,
+		VisibilityTimeout:      1,
+		MessageRetentionPeriod: 60,
+		HandlerTimeout:         5,
+	}
+}
+
+func TestInstanceQ_MemoryTransport(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	var mu sync.Mutex
+	var attempts int
+	received := make(chan *M, 10)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			return goErrors.New("first attempt fails")
+		}
+		received <- msg
+		return nil
+	})
+
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, testConfig(topic))
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, h))
+
+	_, err = transport.Publish(ctx, &PublishInput{
+		TopicArn: topic,
+		Body:     `{"name":"value"}`,
+		Attributes: map[string]MessageAttribute{
+			MessageAttributeReqID: {Type: "String", Value: "req-1"},
+		},
+	})
+	require.NoError(t, err)
+
+	select {
+	case m := <-received:
+		require.Equal(t, "value", m.Body["name"])
+		require.Equal(t, topic, m.Notification.TopicArn)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not redelivered after the visibility timeout")
+	}
+
+	require.Eventually(t, func() bool {
+		return transport.Messages(q.qurl) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, q.Stop(ctx))
+	require.Equal(t, 0, transport.Messages(q.qurl))
+	_, err = transport.ReceiveMessages(ctx, q.qurl, ReceiveOptions{})
+	require.True(t, goErrors.Is(err, ErrQueueDoesNotExist))
+}
+
+func TestInstanceQ_DeadLetterQueue(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	var mu sync.Mutex
+	failing := true
+	handled := make(chan *M, 10)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return goErrors.New("handler failure")
+		}
+		handled <- msg
+		return nil
+	})
+
+	c := testConfig(topic)
+	c.DeadLetterQueue = true
+	c.MaxReceiveCount = 1
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, c)
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, h))
+	defer func() { _ = q.Stop(ctx) }()
+
+	_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{"name":"value"}`})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return transport.Messages(q.dlqurl) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	dead, err := q.DeadLetters(ctx, 10, 1)
+	require.NoError(t, err)
+	require.Len(t, dead, 1)
+	require.Equal(t, "value", dead[0].Body["name"])
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+
+	require.NoError(t, q.ReplayDeadLetter(ctx, dead[0]))
+
+	select {
+	case m := <-handled:
+		require.Equal(t, "value", m.Body["name"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("replayed message was not handled")
+	}
+	require.Equal(t, 0, transport.Messages(q.dlqurl))
+}
+
+func TestInstanceQ_MaxInFlightAndStop(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	started := make(chan struct{}, 10)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	c := testConfig(topic)
+	c.MaxInFlight = 2
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, c)
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, h))
+
+	for i := 0; i < 5; i++ {
+		_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{}`})
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(2 * time.Second):
+			t.Fatal("handler was not started")
+		}
+	}
+	select {
+	case <-started:
+		t.Fatal("more handlers started than MaxInFlight allows")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	stopCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	require.Equal(t, context.DeadlineExceeded, q.Stop(stopCtx))
+}
+
+func TestInstanceQ_VisibilityHeartbeatAndRelease(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	var mu sync.Mutex
+	var calls int
+	var released bool
+	done := make(chan bool, 1)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		switch n {
+		case 1:
+			// outlive the one second visibility timeout, the heartbeat keeps the message hidden
+			time.Sleep(1500 * time.Millisecond)
+			mu.Lock()
+			released = true
+			mu.Unlock()
+			return Release(ctx)
+		case 2:
+			mu.Lock()
+			done <- released
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	c := testConfig(topic)
+	c.VisibilityExtension = 1
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, c)
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, h))
+	defer func() { _ = q.Stop(ctx) }()
+
+	_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{}`})
+	require.NoError(t, err)
+
+	select {
+	case r := <-done:
+		require.True(t, r, "message was redelivered while its handler was still running")
+	case <-time.After(5 * time.Second):
+		t.Fatal("released message was not redelivered")
+	}
+	mu.Lock()
+	require.Equal(t, 2, calls)
+	mu.Unlock()
+	require.Eventually(t, func() bool {
+		return transport.Messages(q.qurl) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, ErrNotInFlight, ExtendVisibility(ctx, time.Second))
+}
+
+type flakyTransport struct {
+	*MemoryTransport
+	mu   sync.Mutex
+	fail bool
+}
+
+func (t *flakyTransport) setFail(fail bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fail = fail
+}
+
+func (t *flakyTransport) ReceiveMessages(ctx context.Context, qurl string, opts ReceiveOptions) ([]*Message, error) {
+	t.mu.Lock()
+	fail := t.fail
+	t.mu.Unlock()
+	if fail {
+		return nil, goErrors.New("transient receive error")
+	}
+	return t.MemoryTransport.ReceiveMessages(ctx, qurl, opts)
+}
+
+func TestInstanceQ_ReceiveErrors(t *testing.T) {
+	ctx := context.Background()
+	transport := &flakyTransport{MemoryTransport: NewMemoryTransport(), fail: true}
+	topic := transport.TopicArn("events")
+
+	c := testConfig(topic)
+	c.FailureThreshold = 2
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, c)
+	require.NoError(t, err)
+	require.Error(t, q.Ready())
+	require.NoError(t, q.Start(ctx, HandlerFunc(func(ctx context.Context, msg *M) error { return nil })))
+
+	require.Eventually(t, func() bool {
+		return q.Health().State == HealthStateFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Error(t, q.Ready())
+	require.Contains(t, q.Health().LastError, "transient receive error")
+
+	transport.setFail(false)
+	require.Eventually(t, func() bool {
+		return q.Health().State == HealthStateHealthy
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, q.Ready())
+
+	require.NoError(t, q.Stop(ctx))
+	require.Equal(t, HealthStateFailed, q.Health().State)
+}
+
+func TestInstanceQ_Envelopes(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("events")
+
+	received := make(chan *M, 10)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		received <- msg
+		return nil
+	})
+
+	c := testConfig(topic)
+	c.RawMessageDelivery = true
+	q, err := NewInstanceQWithTransport("svc-abc123-xyz", transport, c)
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, h))
+	defer func() { _ = q.Stop(ctx) }()
+
+	next := func() *M {
+		select {
+		case m := <-received:
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatal("message was not handled")
+		}
+		return nil
+	}
+
+	_, err = transport.Publish(ctx, &PublishInput{
+		TopicArn: topic,
+		Body:     `{"name":"raw"}`,
+		Attributes: map[string]MessageAttribute{
+			MessageAttributeType: {Type: "String", Value: "user.created"},
+		},
+	})
+	require.NoError(t, err)
+	m := next()
+	require.Equal(t, EnvelopeRaw, m.Envelope)
+	require.Equal(t, "raw", m.Body["name"])
+	v, ok := m.Attribute(MessageAttributeType)
+	require.True(t, ok)
+	require.Equal(t, "user.created", v)
+	require.False(t, m.SentAt().IsZero())
+
+	_, err = transport.SendMessage(ctx, q.qurl, `{
+		"version": "0",
+		"id": "event-1",
+		"detail-type": "user.deleted",
+		"source": "app.users",
+		"time": "2021-01-02T03:04:05Z",
+		"detail": {"name":"event"}
+	}`, nil)
+	require.NoError(t, err)
+	m = next()
+	require.Equal(t, EnvelopeEventBridge, m.Envelope)
+	require.Equal(t, "event", m.Body["name"])
+	require.Equal(t, "app.users", m.Event.Source)
+	require.Equal(t, "user.deleted", NewRouter().Type(m))
+	require.Equal(t, time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC), m.SentAt())
+
+	_, err = transport.SendMessage(ctx, q.qurl, "not json", nil)
+	require.NoError(t, err)
+	m = next()
+	require.Equal(t, EnvelopeRaw, m.Envelope)
+	require.Equal(t, []byte("not json"), m.RawBody)
+}
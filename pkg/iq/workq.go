@@ -0,0 +1,68 @@
+package iq
+
+import (
+	"context"
+	goErrors "errors"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// WorkQ is a competing consumer on a queue shared by every instance of a service, so each message is handled by
+// a single instance. The queue is created from Config unless it already exists, either way it is subscribed to the
+// configured topics with their filter policies and gets a dead-letter queue when enabled. Unlike an InstanceQ the queue outlives the WorkQ, Stop leaves the queue and its
+// subscriptions in place.
+type WorkQ struct {
+	*consumer
+}
+
+func NewWorkQ(queueName string, sess *session.Session, c *Config, opts ...Option) (*WorkQ, error) {
+	return NewWorkQWithTransport(queueName, NewAWSTransport(sess), c, opts...)
+}
+
+// NewWorkQWithTransport creates a WorkQ that uses t for every queue and topic operation
+func NewWorkQWithTransport(queueName string, t Transport, c *Config, opts ...Option) (*WorkQ, error) {
+	return &WorkQ{
+		consumer: newConsumer("work queue", queueName, t, c, opts),
+	}, nil
+}
+
+// attachQ looks up the shared queue, and its dead-letter queue when enabled, creating them if they don't exist
+func (q *WorkQ) attachQ(ctx context.Context) error {
+	tags := map[string]string{
+		"Prefix": q.c.Prefix,
+	}
+	existing, err := q.transport.GetQueue(ctx, q.name)
+	if goErrors.Is(err, ErrQueueDoesNotExist) {
+		return q.createQueue(ctx, q.name, tags)
+	}
+	if err != nil {
+		return err
+	}
+
+	q.qurl = existing.URL
+	q.qarn = existing.ARN
+
+	if q.c.DeadLetterQueue {
+		if err := q.attachDLQ(ctx, q.name, tags); err != nil {
+			return err
+		}
+	}
+
+	return q.subscribe(ctx)
+}
+
+func (q *WorkQ) Start(ctx context.Context, h Handler) error {
+	if err := q.attachQ(ctx); err != nil {
+		return err
+	}
+
+	q.start(h)
+	return nil
+}
+
+// Stop stops receiving messages and waits for in-flight handlers to finish until ctx is done, messages still being
+// handled at that point are released back to the shared queue. Stop returns ctx.Err() if the handlers did not
+// drain in time.
+func (q *WorkQ) Stop(ctx context.Context) error {
+	return q.stop(ctx)
+}
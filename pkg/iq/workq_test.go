@@ -0,0 +1,141 @@
+package iq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/log"
+)
+
+func TestWorkQ_CompetingConsumers(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("jobs")
+
+	var mu sync.Mutex
+	handled := make(map[string]int)
+	h := HandlerFunc(func(ctx context.Context, msg *M) error {
+		mu.Lock()
+		defer mu.Unlock()
+		handled[msg.Body["job"].(string)]++
+		return nil
+	})
+
+	var workers []*WorkQ
+	for i := 0; i < 2; i++ {
+		q, err := NewWorkQWithTransport("test_jobs", transport, testConfig(topic))
+		require.NoError(t, err)
+		require.NoError(t, q.Start(ctx, h))
+		workers = append(workers, q)
+	}
+	require.Equal(t, workers[0].qurl, workers[1].qurl)
+
+	jobs := []string{"a", "b", "c", "d", "e"}
+	for _, job := range jobs {
+		_, err := transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{"job":"` + job + `"}`})
+		require.NoError(t, err)
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == len(jobs)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, q := range workers {
+		require.NoError(t, q.Stop(ctx))
+	}
+	mu.Lock()
+	for _, job := range jobs {
+		require.Equal(t, 1, handled[job], job)
+	}
+	mu.Unlock()
+
+	// the shared queue and its subscription outlive the workers
+	_, err := transport.GetQueue(ctx, "test_jobs")
+	require.NoError(t, err)
+	_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{"job":"f"}`})
+	require.NoError(t, err)
+	require.Equal(t, 1, transport.Messages(workers[0].qurl))
+}
+
+func TestWorkQ_ExistingQueue(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+
+	existing, err := transport.CreateQueue(ctx, "provisioned", nil, nil)
+	require.NoError(t, err)
+	_, err = transport.SendMessage(ctx, existing.URL, `{"job":"a"}`, map[string]MessageAttribute{
+		MessageAttributeReqID: {Type: "String", Value: "req-1"},
+	})
+	require.NoError(t, err)
+
+	received := make(chan string, 1)
+	q, err := NewWorkQWithTransport("provisioned", transport, testConfig())
+	require.NoError(t, err)
+	require.NoError(t, q.Start(ctx, HandlerFunc(func(ctx context.Context, msg *M) error {
+		received <- log.GetReqID(ctx)
+		return nil
+	})))
+	defer func() { _ = q.Stop(ctx) }()
+
+	select {
+	case reqID := <-received:
+		require.Equal(t, "req-1", reqID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("message was not handled")
+	}
+	require.Eventually(t, func() bool {
+		return transport.Messages(existing.URL) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWorkQ_ExistingQueueReconciled(t *testing.T) {
+	ctx := context.Background()
+	transport := NewMemoryTransport()
+	topic := transport.TopicArn("jobs")
+
+	existing, err := transport.CreateQueue(ctx, "provisioned", nil, nil)
+	require.NoError(t, err)
+
+	c := testConfig(topic)
+	c.DeadLetterQueue = true
+	c.MaxReceiveCount = 3
+	q, err := NewWorkQWithTransport("provisioned", transport, c,
+		WithFilterPolicy(topic, FilterPolicy{Policy: []byte(`{"x_msg_type": ["job.created"]}`)}))
+	require.NoError(t, err)
+	require.NoError(t, q.attachQ(ctx))
+
+	// the existing queue gets a dead-letter queue and its redrive policy
+	require.NotEmpty(t, q.dlqurl)
+	attributes, err := transport.GetQueueAttributes(ctx, existing.URL)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"deadLetterTargetArn": "`+q.dlqarn+`", "maxReceiveCount": "3"}`, attributes["RedrivePolicy"])
+
+	// and is subscribed to the topics with their filter policies
+	require.Len(t, q.subscriptions, 1)
+	attributes, err = transport.GetSubscriptionAttributes(ctx, q.subscriptions[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"x_msg_type": ["job.created"]}`, attributes["FilterPolicy"])
+
+	// a changed policy is reconciled on the next deploy
+	q, err = NewWorkQWithTransport("provisioned", transport, c,
+		WithFilterPolicy(topic, FilterPolicy{Policy: []byte(`{"x_msg_type": ["job.deleted"]}`)}))
+	require.NoError(t, err)
+	require.NoError(t, q.attachQ(ctx))
+	attributes, err = transport.GetSubscriptionAttributes(ctx, q.subscriptions[0])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"x_msg_type": ["job.deleted"]}`, attributes["FilterPolicy"])
+
+	for _, msgType := range []string{"job.created", "job.deleted"} {
+		_, err = transport.Publish(ctx, &PublishInput{TopicArn: topic, Body: `{}`, Attributes: map[string]MessageAttribute{
+			MessageAttributeType: {Type: "String", Value: msgType},
+		}})
+		require.NoError(t, err)
+	}
+	require.Equal(t, 1, transport.Messages(existing.URL))
+}
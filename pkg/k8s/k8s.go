@@ -1,7 +1,11 @@
 package k8s
 
 import (
+	"context"
+
 	"github.com/unanet/go/v2/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -36,3 +40,15 @@ func GetLocalConfigK8sClient() (*kubernetes.Clientset, error) {
 	return client, nil
 
 }
+
+// PodExists reports whether a pod with the given name exists in namespace
+func PodExists(ctx context.Context, client kubernetes.Interface, namespace string, name string) (bool, error) {
+	_, err := client.CoreV1().Pods(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err)
+	}
+	return true, nil
+}
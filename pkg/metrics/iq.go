@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	StatIQPublishCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iq_publish_total",
+			Help: "The total number of notifications published to SNS topics",
+		}, []string{"topic_arn", "status"})
+
+	StatIQPublishDurationHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iq_publish_duration_seconds",
+			Help:    "time spent publishing notifications to an SNS topic in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.01, 1.6, 20),
+		}, []string{"topic_arn"})
+)
+
+var (
+	StatIQMessagesReceivedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iq_messages_received_total",
+			Help: "The total number of messages received from iq queues",
+		}, []string{"topic_arn", "prefix"})
+
+	StatIQMessagesHandledCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iq_messages_handled_total",
+			Help: "The total number of messages successfully handled",
+		}, []string{"topic_arn", "prefix"})
+
+	StatIQMessagesFailedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iq_messages_failed_total",
+			Help: "The total number of messages whose handler returned an error",
+		}, []string{"topic_arn", "prefix"})
+
+	StatIQMessagesDeletedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iq_messages_deleted_total",
+			Help: "The total number of messages deleted from iq queues",
+		}, []string{"topic_arn", "prefix"})
+
+	StatIQHandlerDurationHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iq_handler_duration_seconds",
+			Help:    "time spent handling a message in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.01, 1.6, 20),
+		}, []string{"topic_arn", "prefix"})
+
+	StatIQMessageAgeHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iq_message_age_seconds",
+			Help:    "time between a notification being published and received in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 20),
+		}, []string{"topic_arn", "prefix"})
+
+	StatIQReceiveDurationHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iq_receive_duration_seconds",
+			Help:    "time spent polling an iq queue for messages in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.01, 1.6, 20),
+		}, []string{"prefix"})
+
+	StatIQInFlightGauge = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "iq_messages_in_flight",
+			Help: "The number of messages currently being handled",
+		}, []string{"prefix"})
+)
+
+var (
+	StatIQHandlerMessageTypeCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "iq_handler_messages_total",
+			Help: "The total number of messages handled by message type and outcome",
+		}, []string{"msg_type", "status"})
+
+	StatIQHandlerMessageTypeDurationHistogram = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "iq_handler_message_type_duration_seconds",
+			Help:    "time spent handling a message by message type in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.01, 1.6, 20),
+		}, []string{"msg_type"})
+)
@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/go-chi/chi/v5"
+
+	"github.com/unanet/go/v2/pkg/auth"
+)
+
+type authOptions struct {
+	routePattern bool
+	abac         bool
+	claims       []string
+	serviceKeys  *auth.ServiceKeys
+	roleMapping  *auth.RoleMapping
+	adminRoles   []string
+	adminBypass  bool
+}
+
+type AuthOption func(*authOptions)
+
+// WithRoutePattern enforces policies against the chi route pattern of the request, e.g. /users/{id}, instead of its
+// URL path
+func WithRoutePattern() AuthOption {
+	return func(o *authOptions) {
+		o.routePattern = true
+	}
+}
+
+// WithABAC enforces policies against the route pattern with the auth.Resource of the request as fourth value, which
+// holds the URL params and the claims named by claims. The request definition of the model has to be
+// r = sub, obj, act, res. Matchers can read the params and claims through the functions added by
+// RegisterABACFunctions, e.g. claim(r.res, "org") == param(r.res, "org").
+func WithABAC(claims ...string) AuthOption {
+	return func(o *authOptions) {
+		o.routePattern = true
+		o.abac = true
+		o.claims = append(o.claims, claims...)
+	}
+}
+
+// WithServiceKeys authenticates requests whose bearer token is one of keys as the service the key belongs to. Services
+// are authorized through the policies for the roles of their key like users, and every use of a key is audit logged.
+func WithServiceKeys(keys *auth.ServiceKeys) AuthOption {
+	return func(o *authOptions) {
+		o.serviceKeys = keys
+	}
+}
+
+// WithAdminRoles sets the roles of the adminToken of AuthenticationMiddleware, which defaults to the role admin
+func WithAdminRoles(roles ...string) AuthOption {
+	return func(o *authOptions) {
+		o.adminRoles = roles
+	}
+}
+
+// WithAdminBypass lets the adminToken of AuthenticationMiddleware through without enforcing any policy, like it did
+// before it became a service key. Its use is still audit logged. It is meant for the upgrade only, add policies for
+// the admin roles instead.
+//
+// Deprecated: add policies for the roles of the adminToken, see WithAdminRoles.
+func WithAdminBypass() AuthOption {
+	return func(o *authOptions) {
+		o.adminBypass = true
+	}
+}
+
+// WithRoleMapping extracts the roles of tokens with m instead of auth.DefaultRoleMapping, e.g. for tokens of identity
+// providers other than Keycloak
+func WithRoleMapping(m auth.RoleMapping) AuthOption {
+	return func(o *authOptions) {
+		o.roleMapping = &m
+	}
+}
+
+// RegisterABACFunctions adds the param(r.res, name) and claim(r.res, name) functions to the matchers of e, both
+// return an empty string when the value is missing
+func RegisterABACFunctions(e *casbin.Enforcer) {
+	e.AddFunction("param", func(args ...interface{}) (interface{}, error) {
+		res, name, err := abacArgs("param", args)
+		if err != nil {
+			return nil, err
+		}
+		return res.Params[name], nil
+	})
+	e.AddFunction("claim", func(args ...interface{}) (interface{}, error) {
+		res, name, err := abacArgs("claim", args)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := res.Claims[name]; ok && v != nil {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return fmt.Sprintf("%v", v), nil
+		}
+		return "", nil
+	})
+}
+
+func abacArgs(fn string, args []interface{}) (auth.Resource, string, error) {
+	if len(args) != 2 {
+		return auth.Resource{}, "", fmt.Errorf("%s expects 2 arguments, got %d", fn, len(args))
+	}
+	name, ok := args[1].(string)
+	if !ok {
+		return auth.Resource{}, "", fmt.Errorf("%s expects a name, got %T", fn, args[1])
+	}
+	switch res := args[0].(type) {
+	case auth.Resource:
+		return res, name, nil
+	case *auth.Resource:
+		return *res, name, nil
+	default:
+		return auth.Resource{}, "", fmt.Errorf("%s expects an auth.Resource, got %T", fn, args[0])
+	}
+}
+
+// resource describes r for the policies, resolving its route when the middleware runs before chi routed it
+func (o authOptions) resource(r *http.Request, claims map[string]interface{}) auth.Resource {
+	res := auth.Resource{
+		Pattern: r.URL.Path,
+		Path:    r.URL.Path,
+		Method:  r.Method,
+		Params:  map[string]string{},
+	}
+
+	if o.routePattern {
+		if pattern, params, ok := route(r); ok {
+			res.Pattern = pattern
+			res.Params = params
+		}
+	}
+
+	if len(o.claims) > 0 {
+		res.Claims = make(map[string]interface{}, len(o.claims))
+		for _, name := range o.claims {
+			if v, ok := claims[name]; ok {
+				res.Claims[name] = v
+			}
+		}
+	}
+
+	return res
+}
+
+// route returns the route pattern and URL params of r. Middlewares added with Use run before their Mux found the
+// route, in that case the route is looked up from the root router, which chi keeps in the route context.
+func route(r *http.Request) (string, map[string]string, bool) {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return "", nil, false
+	}
+
+	x := rctx
+	patterns := rctx.RoutePatterns
+	if len(patterns) == 0 || strings.HasSuffix(patterns[len(patterns)-1], "/*") {
+		if rctx.Routes == nil {
+			return "", nil, false
+		}
+		path := r.URL.RawPath
+		if path == "" {
+			path = r.URL.Path
+		}
+		x = chi.NewRouteContext()
+		if !rctx.Routes.Match(x, r.Method, path) {
+			return "", nil, false
+		}
+	}
+
+	params := make(map[string]string, len(x.URLParams.Keys))
+	for i, key := range x.URLParams.Keys {
+		if key != "*" {
+			params[key] = x.URLParams.Values[i]
+		}
+	}
+	return x.RoutePattern(), params, true
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/auth"
+)
+
+func TestRoute(t *testing.T) {
+	var res auth.Resource
+	o := authOptions{routePattern: true}
+	capture := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res = o.resource(r, nil)
+			next.ServeHTTP(w, r)
+		})
+	}
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+
+	orgs := chi.NewRouter()
+	orgs.Use(capture)
+	orgs.Get("/{org}/users/{id}", noop)
+
+	r := chi.NewRouter()
+	r.Use(capture)
+	r.Mount("/orgs", orgs)
+	r.With(capture).Get("/health", noop)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orgs/acme/users/42", nil))
+	require.Equal(t, "/orgs/{org}/users/{id}", res.Pattern)
+	require.Equal(t, "/orgs/acme/users/42", res.Path)
+	require.Equal(t, map[string]string{"org": "acme", "id": "42"}, res.Params)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health", nil))
+	require.Equal(t, "/health", res.Pattern)
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	require.Equal(t, "/missing", res.Pattern)
+}
+
+func TestABAC(t *testing.T) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act, res
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && keyMatch2(r.obj, p.obj) && r.act == p.act && claim(r.res, "org") == param(r.res, "org")
+`)
+	require.NoError(t, err)
+	e, err := casbin.NewEnforcer(m)
+	require.NoError(t, err)
+	RegisterABACFunctions(e)
+	_, err = e.AddPolicy("member", "/orgs/{org}/users/{id}", http.MethodGet)
+	require.NoError(t, err)
+
+	o := authOptions{}
+	WithABAC("org")(&o)
+	res := auth.Resource{
+		Pattern: "/orgs/{org}/users/{id}",
+		Params:  map[string]string{"org": "acme", "id": "42"},
+		Claims:  map[string]interface{}{"org": "acme"},
+	}
+
+	ok, policy, err := e.EnforceEx("member", res.Pattern, http.MethodGet, res)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, []string{"member", "/orgs/{org}/users/{id}", http.MethodGet}, policy)
+
+	res.Claims["org"] = "other"
+	ok, _, err = e.EnforceEx("member", res.Pattern, http.MethodGet, res)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
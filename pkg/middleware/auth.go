@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	goErrors "errors"
 	"fmt"
 	"net/http"
 
@@ -17,31 +18,70 @@ import (
 	"github.com/unanet/go/v2/pkg/identity"
 )
 
-func extractRoles(ctx context.Context, claims jwt.MapClaims) []interface{} {
+func (o authOptions) extractRoles(ctx context.Context, claims jwt.MapClaims) []string {
 	Log(ctx).Debug("extract role from incoming claims", zap.Any("claims", claims))
-	if ra, ok := claims["realm_access"].(map[string]interface{}); ok {
-		if roles, ok := ra["roles"].([]interface{}); ok {
-			Log(ctx).Debug("incoming claim roles slice found", zap.Any("role", roles))
-			return roles
-		}
+	mapping := auth.DefaultRoleMapping
+	if o.roleMapping != nil {
+		mapping = *o.roleMapping
+	}
+
+	roles := mapping.Roles(claims)
+	if len(roles) == 0 {
+		Log(ctx).Debug("unknown role extracted")
+		return []string{}
 	}
 
-	Log(ctx).Debug("unknown role extracted")
-	return []interface{}{}
+	Log(ctx).Debug("incoming claim roles slice found", zap.Strings("role", roles))
+	return roles
 }
 
-func AuthenticationMiddleware(adminToken string, idv *identity.Validator, enforcer *casbin.Enforcer) func(http.Handler) http.Handler {
+// AuthenticationMiddleware validates the token of the request and enforces the policies of enforcer for its roles. The
+// decision is recorded in the context of the request, see auth.GetDecision.
+//
+// adminToken is a service key named admin with the role admin, or the roles set with WithAdminRoles, an empty
+// adminToken disables it. Prefer named service keys, see WithServiceKeys.
+//
+// Breaking change: the adminToken used to bypass the enforcer, now it is only granted what the policies allow its
+// roles. Deployments without policies for the admin role lose admin access unless they add them, or keep the old
+// behavior with WithAdminBypass while they do.
+func AuthenticationMiddleware(adminToken string, idv *identity.Validator, enforcer *casbin.Enforcer, opts ...AuthOption) func(http.Handler) http.Handler {
+	o := authOptions{
+		adminRoles: []string{"admin"},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var keys []*auth.ServiceKeys
+	if o.serviceKeys != nil {
+		keys = append(keys, o.serviceKeys)
+	}
+	var admin *auth.ServiceKeys
+	if adminToken != "" {
+		var err error
+		admin, err = auth.NewServiceKeys(auth.ServiceKey{
+			Name:  "admin",
+			Hash:  auth.HashServiceKey(adminToken),
+			Roles: o.adminRoles,
+		})
+		if err != nil {
+			// the hash is always valid
+			panic(err)
+		}
+		keys = append(keys, admin)
+	}
+
 	return func(next http.Handler) http.Handler {
 		hfn := func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
+			token := jwtauth.TokenFromHeader(r)
 
-			// Admin token, you shall PASS!!!
-			if jwtauth.TokenFromHeader(r) == adminToken {
-				ctx = auth.CtxWithClaims(ctx, map[string]interface{}{
-					"sub": "admin",
-				})
-				next.ServeHTTP(w, r.WithContext(ctx))
-				return
+			for _, k := range keys {
+				key, err := k.Authenticate(token)
+				if !goErrors.Is(err, auth.ErrUnknownServiceKey) {
+					o.serveServiceKey(w, r, next, enforcer, key, err, k == admin && o.adminBypass)
+					return
+				}
 			}
 
 			claims, err := idv.Validate(r)
@@ -53,33 +93,120 @@ func AuthenticationMiddleware(adminToken string, idv *identity.Validator, enforc
 
 			Log(ctx).Debug("incoming auth claims", zap.Any("claims", claims))
 
-			var grantedAccess bool
-
-			Log(ctx).Debug(fmt.Sprintf("checking auth for URL = %s, Method = %s", r.URL.Path, r.Method))
-
-			// Range over the roles to see if we have access to the resource
-			for _, role := range extractRoles(ctx, claims) {
-				grantedAccess, err = enforcer.Enforce(role, r.URL.Path, r.Method)
-				if err != nil {
-					Log(ctx).Error("casbin enforced resulted in an error", zap.Error(err))
-					render.Status(r, 500)
-					return
-				}
-
-				if grantedAccess {
-					Log(ctx).Debug(fmt.Sprintf("access granted. Role = %s, URL = %s, Method = %s", role, r.URL.Path, r.Method))
-					break
-				}
+			r, decision, err := o.authorize(r, enforcer, claims, o.extractRoles(ctx, claims))
+			if err != nil {
+				Log(ctx).Error("casbin enforced resulted in an error", zap.Error(err))
+				render.Respond(w, r, errors.NewRestError(http.StatusInternalServerError, "Internal Server Error"))
+				return
 			}
 
-			if !grantedAccess {
-				Log(ctx).Debug(fmt.Sprintf("not authorized. URL = %s, Method = %s", r.URL.Path, r.Method))
+			if !decision.Allowed {
 				render.Respond(w, r, errors.NewRestError(403, "Forbidden"))
 				return
 			}
 
-			next.ServeHTTP(w, r.WithContext(auth.CtxWithClaims(ctx, claims)))
+			next.ServeHTTP(w, r.WithContext(auth.CtxWithClaims(r.Context(), claims)))
 		}
 		return http.HandlerFunc(hfn)
 	}
 }
+
+// serveServiceKey serves the request of a service that authenticated with key, err is set when the key expired. bypass
+// skips the enforcer, see WithAdminBypass.
+func (o authOptions) serveServiceKey(w http.ResponseWriter, r *http.Request, next http.Handler, enforcer *casbin.Enforcer, key auth.ServiceKey, err error, bypass bool) {
+	ctx := r.Context()
+	fields := []zap.Field{
+		zap.String("service", key.Name),
+		zap.Strings("roles", key.Roles),
+		zap.String("method", r.Method),
+		zap.String("path", r.URL.Path),
+	}
+
+	if err != nil {
+		Log(ctx).Warn("service key rejected", append(fields, zap.Error(err))...)
+		render.Respond(w, r, err)
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"sub": key.Name,
+	}
+
+	if bypass {
+		r = o.bypass(r, claims, key.Roles)
+		Log(ctx).Info("service key used", append(fields, zap.Bool("allowed", true), zap.Bool("bypass", true))...)
+		next.ServeHTTP(w, r.WithContext(auth.CtxWithClaims(r.Context(), claims)))
+		return
+	}
+
+	r, decision, err := o.authorize(r, enforcer, claims, key.Roles)
+	if err != nil {
+		Log(ctx).Error("casbin enforced resulted in an error", zap.Error(err))
+		render.Respond(w, r, errors.NewRestError(http.StatusInternalServerError, "Internal Server Error"))
+		return
+	}
+
+	Log(ctx).Info("service key used", append(fields, zap.Bool("allowed", decision.Allowed), zap.String("role", decision.Role))...)
+	if !decision.Allowed {
+		render.Respond(w, r, errors.NewRestError(403, "Forbidden"))
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(auth.CtxWithClaims(r.Context(), claims)))
+}
+
+// bypass allows r without enforcing any policy and records the decision, the roles and the auth.Principal like
+// authorize
+func (o authOptions) bypass(r *http.Request, claims jwt.MapClaims, roles []string) *http.Request {
+	ctx := r.Context()
+	decision := auth.Decision{
+		Subject:  auth.Sub(auth.CtxWithClaims(ctx, claims)),
+		Resource: o.resource(r, claims),
+		Allowed:  true,
+	}
+	ctx = auth.CtxWithRoles(auth.CtxWithDecision(ctx, decision), roles)
+	ctx = auth.CtxWithPrincipal(ctx, auth.NewPrincipal(claims, roles))
+	return r.WithContext(ctx)
+}
+
+// authorize enforces the policies of enforcer for roles and records the decision, the roles and the auth.Principal in
+// the context of the returned request
+func (o authOptions) authorize(r *http.Request, enforcer *casbin.Enforcer, claims jwt.MapClaims, roles []string) (*http.Request, auth.Decision, error) {
+	ctx := r.Context()
+	res := o.resource(r, claims)
+	decision := auth.Decision{
+		Subject:  auth.Sub(auth.CtxWithClaims(ctx, claims)),
+		Resource: res,
+	}
+
+	Log(ctx).Debug(fmt.Sprintf("checking auth for URL = %s, Pattern = %s, Method = %s", r.URL.Path, res.Pattern, r.Method))
+
+	// Range over the roles to see if we have access to the resource
+	for _, role := range roles {
+		rvals := []interface{}{role, res.Pattern, r.Method}
+		if o.abac {
+			rvals = append(rvals, res)
+		}
+
+		grantedAccess, policy, err := enforcer.EnforceEx(rvals...)
+		if err != nil {
+			return r, decision, err
+		}
+
+		if grantedAccess {
+			decision.Allowed = true
+			decision.Role = role
+			decision.Policy = policy
+			Log(ctx).Debug(fmt.Sprintf("access granted. Role = %s, URL = %s, Method = %s", role, r.URL.Path, r.Method), zap.Strings("policy", policy))
+			break
+		}
+	}
+
+	if !decision.Allowed {
+		Log(ctx).Debug(fmt.Sprintf("not authorized. URL = %s, Method = %s", r.URL.Path, r.Method))
+	}
+
+	ctx = auth.CtxWithRoles(auth.CtxWithDecision(ctx, decision), roles)
+	ctx = auth.CtxWithPrincipal(ctx, auth.NewPrincipal(claims, roles))
+	return r.WithContext(ctx), decision, nil
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/auth"
+)
+
+func TestAuthenticationMiddleware_ServiceKeys(t *testing.T) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && keyMatch2(r.obj, p.obj) && r.act == p.act
+`)
+	require.NoError(t, err)
+	e, err := casbin.NewEnforcer(m)
+	require.NoError(t, err)
+	_, err = e.AddPolicy("reader", "/invoices/{id}", http.MethodGet)
+	require.NoError(t, err)
+
+	expired := time.Now().Add(-time.Minute)
+	keys, err := auth.NewServiceKeys(
+		auth.ServiceKey{Name: "billing", Hash: auth.HashServiceKey("billing-key"), Roles: []string{"writer", "reader"}},
+		auth.ServiceKey{Name: "legacy", Hash: auth.HashServiceKey("legacy-key"), Roles: []string{"reader"}, ExpiresAt: &expired},
+	)
+	require.NoError(t, err)
+
+	var decision auth.Decision
+	var sub string
+	var roles []string
+	var principal *auth.Principal
+	r := chi.NewRouter()
+	r.Use(AuthenticationMiddleware("admin-token", nil, e, WithRoutePattern(), WithServiceKeys(keys), WithAdminRoles("reader")))
+	r.Get("/invoices/{id}", func(w http.ResponseWriter, r *http.Request) {
+		decision, _ = auth.GetDecision(r.Context())
+		sub = auth.Sub(r.Context())
+		roles = auth.Roles(r.Context())
+		principal, _ = auth.GetPrincipal(r.Context())
+	})
+	r.Delete("/invoices/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	serve := func(method string, key string) int {
+		req := httptest.NewRequest(method, "/invoices/42", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "billing-key"))
+	require.Equal(t, "billing", sub)
+	require.Equal(t, []string{"writer", "reader"}, roles)
+	require.NotNil(t, principal)
+	require.Equal(t, "billing", principal.Subject)
+	require.Equal(t, []string{"writer", "reader"}, principal.Roles)
+	require.True(t, decision.Allowed)
+	require.Equal(t, "reader", decision.Role)
+	require.Equal(t, []string{"reader", "/invoices/{id}", http.MethodGet}, decision.Policy)
+	require.Equal(t, map[string]string{"id": "42"}, decision.Resource.Params)
+
+	require.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "billing-key"))
+
+	// the admin token is authorized like every other service key
+	require.Equal(t, http.StatusOK, serve(http.MethodGet, "admin-token"))
+	require.Equal(t, "admin", sub)
+	require.Equal(t, "reader", decision.Role)
+	require.Equal(t, http.StatusForbidden, serve(http.MethodDelete, "admin-token"))
+	require.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "legacy-key"))
+}
+
+func TestAuthenticationMiddleware_AdminBypass(t *testing.T) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	require.NoError(t, err)
+	e, err := casbin.NewEnforcer(m)
+	require.NoError(t, err)
+	keys, err := auth.NewServiceKeys(auth.ServiceKey{Name: "admin", Hash: auth.HashServiceKey("other-key"), Roles: []string{"admin"}})
+	require.NoError(t, err)
+
+	var sub string
+	h := AuthenticationMiddleware("admin-token", nil, e, WithServiceKeys(keys), WithAdminBypass())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub = auth.Sub(r.Context())
+	}))
+	serve := func(key string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/invoices", nil)
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// there is no policy for the admin role, the adminToken still gets through
+	require.Equal(t, http.StatusOK, serve("admin-token"))
+	require.Equal(t, "admin", sub)
+	// service keys named admin are not bypassed
+	require.Equal(t, http.StatusForbidden, serve("other-key"))
+}
+
+func TestAuthenticationMiddleware_EnforcerError(t *testing.T) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	require.NoError(t, err)
+	e, err := casbin.NewEnforcer(m)
+	require.NoError(t, err)
+	_, err = e.AddPolicy("reader", "/invoices", http.MethodGet)
+	require.NoError(t, err)
+
+	keys, err := auth.NewServiceKeys(auth.ServiceKey{Name: "billing", Hash: auth.HashServiceKey("billing-key"), Roles: []string{"reader"}})
+	require.NoError(t, err)
+
+	// WithABAC passes four values to a model that expects three
+	h := AuthenticationMiddleware("", nil, e, WithServiceKeys(keys), WithABAC())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "/invoices", nil)
+	req.Header.Set("Authorization", "Bearer billing-key")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.Contains(t, w.Body.String(), "Internal Server Error")
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+
+	"github.com/unanet/go/v2/pkg/auth"
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+// ErrTooManyRequests is responded with when a request is rate limited
+var ErrTooManyRequests = errors.NewRestError(http.StatusTooManyRequests, "TooManyRequests")
+
+// KeyFunc returns the key a request is rate limited by
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP limits requests per client IP. Use it after middleware.RealIP so proxies are not limited as one client.
+func KeyByIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// KeyBySub limits requests per JWT subject, falling back to the client IP for requests without claims. Use it after
+// the AuthenticationMiddleware.
+func KeyBySub(r *http.Request) string {
+	if _, ok := auth.Claims(r.Context())["sub"]; ok {
+		return "sub:" + auth.Sub(r.Context())
+	}
+	return "ip:" + KeyByIP(r)
+}
+
+// Middleware responds with a 429 ErrTooManyRequests and a Retry-After header to requests over the limit of their key
+func Middleware(l Limiter, key KeyFunc) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter := l.Allow(key(r))
+			if !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				render.Status(r, http.StatusTooManyRequests)
+				render.Respond(w, r, ErrTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
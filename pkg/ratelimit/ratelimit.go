@@ -0,0 +1,227 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/unanet/go/v2/pkg/clock"
+)
+
+// Limiter limits the rate of calls per key, e.g. per client IP or per downstream host
+type Limiter interface {
+	// Allow takes a token for key. When none is left it returns false and how long until the call may be retried.
+	Allow(key string) (bool, time.Duration)
+	// Wait blocks until a token for key is available or ctx is done
+	Wait(ctx context.Context, key string) error
+}
+
+type options struct {
+	clock clock.Clock
+}
+
+type Option func(*options)
+
+// WithClock makes the Limiter read the time from c, e.g. a clock.Fake in tests
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		clock: clock.Real,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// sweepInterval is how often limiters drop the state of keys that are back to their full allowance
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// TokenBucket allows bursts of up to burst calls per key, refilled at rate tokens per second
+type TokenBucket struct {
+	rate  float64
+	burst float64
+	clock clock.Clock
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewTokenBucket(rate float64, burst int, opts ...Option) *TokenBucket {
+	o := newOptions(opts)
+	return &TokenBucket{
+		rate:      rate,
+		burst:     float64(burst),
+		clock:     o.clock,
+		buckets:   make(map[string]*bucket),
+		lastSweep: o.clock.Now(),
+	}
+}
+
+func (l *TokenBucket) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if l.rate <= 0 {
+		return false, sweepInterval
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *TokenBucket) Wait(ctx context.Context, key string) error {
+	return wait(ctx, l, l.clock, key)
+}
+
+func (l *TokenBucket) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.rate
+	if tokens > l.burst {
+		return l.burst
+	}
+	return tokens
+}
+
+func (l *TokenBucket) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type window struct {
+	start    time.Time
+	count    int
+	previous int
+}
+
+// SlidingWindow allows limit calls per key within any window. It counts calls in fixed windows and weighs the count
+// of the previous window by how much of it still overlaps the sliding window.
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+func NewSlidingWindow(limit int, d time.Duration, opts ...Option) *SlidingWindow {
+	o := newOptions(opts)
+	return &SlidingWindow{
+		limit:     limit,
+		window:    d,
+		clock:     o.clock,
+		windows:   make(map[string]*window),
+		lastSweep: o.clock.Now(),
+	}
+}
+
+func (l *SlidingWindow) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok {
+		w = &window{start: now.Truncate(l.window)}
+		l.windows[key] = w
+	}
+	l.advance(w, now)
+
+	elapsed := now.Sub(w.start)
+	overlap := 1 - float64(elapsed)/float64(l.window)
+	if float64(w.previous)*overlap+float64(w.count+1) <= float64(l.limit) {
+		w.count++
+		return true, 0
+	}
+
+	// the weight of the previous window drops until a call fits, unless the current window is full on its own
+	if w.previous > 0 && w.count < l.limit {
+		fits := 1 - float64(l.limit-w.count-1)/float64(w.previous)
+		if d := time.Duration(fits*float64(l.window)) - elapsed; d > 0 {
+			return false, d
+		}
+		return false, 0
+	}
+	return false, l.window - elapsed
+}
+
+func (l *SlidingWindow) Wait(ctx context.Context, key string) error {
+	return wait(ctx, l, l.clock, key)
+}
+
+// advance moves w to the fixed window now falls in
+func (l *SlidingWindow) advance(w *window, now time.Time) {
+	start := now.Truncate(l.window)
+	switch {
+	case start.Equal(w.start):
+	case start.Sub(w.start) == l.window:
+		w.previous, w.count = w.count, 0
+		w.start = start
+	default:
+		w.previous, w.count = 0, 0
+		w.start = start
+	}
+}
+
+func (l *SlidingWindow) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= 2*l.window {
+			delete(l.windows, key)
+		}
+	}
+}
+
+func wait(ctx context.Context, l Limiter, c clock.Clock, key string) error {
+	for {
+		ok, retryAfter := l.Allow(key)
+		if ok {
+			return nil
+		}
+
+		t := c.NewTimer(retryAfter)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C():
+		}
+	}
+}
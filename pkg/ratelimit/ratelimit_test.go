@@ -0,0 +1,120 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/auth"
+	"github.com/unanet/go/v2/pkg/clock"
+)
+
+func TestTokenBucket(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewTokenBucket(2, 3, WithClock(c))
+
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok)
+	}
+	ok, retryAfter := l.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, 500*time.Millisecond, retryAfter)
+
+	ok, _ = l.Allow("b")
+	require.True(t, ok, "keys are limited separately")
+
+	c.Advance(500 * time.Millisecond)
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+	ok, _ = l.Allow("a")
+	require.False(t, ok)
+
+	c.Advance(time.Hour)
+	ok, _ = l.Allow("c")
+	require.True(t, ok)
+	require.Len(t, l.buckets, 1, "full buckets are swept")
+}
+
+func TestSlidingWindow(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewSlidingWindow(4, time.Minute, WithClock(c))
+
+	for i := 0; i < 4; i++ {
+		ok, _ := l.Allow("a")
+		require.True(t, ok)
+	}
+	ok, retryAfter := l.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, time.Minute, retryAfter)
+
+	// half into the next window, 2 of the previous 4 calls still count
+	c.Advance(90 * time.Second)
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+	ok, retryAfter = l.Allow("a")
+	require.False(t, ok)
+	require.Equal(t, 15*time.Second, retryAfter)
+
+	c.Advance(retryAfter)
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+
+	c.Advance(time.Hour)
+	ok, _ = l.Allow("a")
+	require.True(t, ok)
+}
+
+func TestWait(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	l := NewTokenBucket(1, 1, WithClock(c))
+	require.NoError(t, l.Wait(context.Background(), "a"))
+
+	done := make(chan error)
+	go func() {
+		done <- l.Wait(context.Background(), "a")
+	}()
+	c.BlockUntil(1)
+	c.Advance(time.Second)
+	require.NoError(t, <-done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		done <- l.Wait(ctx, "a")
+	}()
+	c.BlockUntil(1)
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+}
+
+func TestMiddleware(t *testing.T) {
+	c := clock.NewFake(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	h := Middleware(NewTokenBucket(0.5, 1, WithClock(c)), KeyBySub)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	serve := func(sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if sub != "" {
+			req = req.WithContext(auth.CtxWithClaims(req.Context(), map[string]interface{}{"sub": sub}))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, serve("alice").Code)
+	w := serve("alice")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+
+	require.Equal(t, http.StatusNoContent, serve("bob").Code)
+	require.Equal(t, http.StatusNoContent, serve("").Code)
+	require.Equal(t, http.StatusTooManyRequests, serve("").Code)
+}
@@ -0,0 +1,345 @@
+package retry
+
+import (
+	"context"
+	goErrors "errors"
+	"sync"
+	"time"
+
+	"github.com/unanet/go/v2/pkg/clock"
+)
+
+// ErrBreakerOpen is returned instead of calling a dependency whose Breaker is open
+var ErrBreakerOpen = goErrors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	// StateClosed lets every call through and counts failures
+	StateClosed BreakerState = iota
+	// StateOpen fails every call with ErrBreakerOpen until the cooldown has passed
+	StateOpen
+	// StateHalfOpen lets a limited number of probe calls through to decide whether to close or open again
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// Outcome is the result of a call a Breaker allowed
+type Outcome int
+
+const (
+	OutcomeSuccess Outcome = iota
+	OutcomeFailure
+	// OutcomeIgnored releases the call without counting it, e.g. when it was cancelled before the dependency answered
+	OutcomeIgnored
+)
+
+// OutcomeOf returns OutcomeSuccess for a nil err, OutcomeIgnored for a cancelled context and OutcomeFailure otherwise
+func OutcomeOf(err error) Outcome {
+	switch {
+	case err == nil:
+		return OutcomeSuccess
+	case goErrors.Is(err, context.Canceled):
+		return OutcomeIgnored
+	default:
+		return OutcomeFailure
+	}
+}
+
+const breakerBuckets = 10
+
+type breakerBucket struct {
+	epoch     int64
+	successes int
+	failures  int
+}
+
+// Breaker is a circuit breaker. It opens once the failures in the rolling window reach the failure threshold, or the
+// failure ratio once enough calls were made, fails calls fast while open and lets probe calls through after the
+// cooldown. The probes close the Breaker when they all succeed and open it again on the first failure.
+type Breaker struct {
+	name             string
+	window           time.Duration
+	failureThreshold int
+	failureRatio     float64
+	minRequests      int
+	cooldown         time.Duration
+	halfOpenRequests int
+	onStateChange    func(name string, from BreakerState, to BreakerState)
+	clock            clock.Clock
+
+	mu        sync.Mutex
+	state     BreakerState
+	buckets   [breakerBuckets]breakerBucket
+	openedAt  time.Time
+	probes    int
+	successes int
+	// generation changes with every state change so outcomes of calls allowed in an earlier state are ignored
+	generation int
+}
+
+type BreakerOpt func(*Breaker)
+
+// WithFailureThreshold opens the Breaker once n calls failed within the window, 0 disables the threshold
+func WithFailureThreshold(n int) BreakerOpt {
+	return func(b *Breaker) {
+		b.failureThreshold = n
+	}
+}
+
+// WithFailureRatio opens the Breaker once the ratio of failed calls within the window reaches ratio, provided at
+// least minRequests calls were made
+func WithFailureRatio(ratio float64, minRequests int) BreakerOpt {
+	return func(b *Breaker) {
+		b.failureRatio = ratio
+		b.minRequests = minRequests
+	}
+}
+
+// WithWindow sets the rolling window failures are counted over
+func WithWindow(d time.Duration) BreakerOpt {
+	return func(b *Breaker) {
+		b.window = d
+	}
+}
+
+// WithCooldown sets how long the Breaker stays open before letting probe calls through
+func WithCooldown(d time.Duration) BreakerOpt {
+	return func(b *Breaker) {
+		b.cooldown = d
+	}
+}
+
+// WithHalfOpenRequests sets the number of probe calls that have to succeed to close the Breaker
+func WithHalfOpenRequests(n int) BreakerOpt {
+	return func(b *Breaker) {
+		b.halfOpenRequests = n
+	}
+}
+
+// OnStateChange registers a callback run on every state change, it is called with the Breaker locked and must not
+// call back into it
+func OnStateChange(fn func(name string, from BreakerState, to BreakerState)) BreakerOpt {
+	return func(b *Breaker) {
+		b.onStateChange = fn
+	}
+}
+
+// WithBreakerClock makes the Breaker read the time from c, e.g. a clock.Fake in tests
+func WithBreakerClock(c clock.Clock) BreakerOpt {
+	return func(b *Breaker) {
+		b.clock = c
+	}
+}
+
+func NewBreaker(name string, opts ...BreakerOpt) *Breaker {
+	b := Breaker{
+		name:             name,
+		window:           time.Minute,
+		failureThreshold: 5,
+		cooldown:         30 * time.Second,
+		halfOpenRequests: 1,
+		clock:            clock.Real,
+	}
+
+	for _, o := range opts {
+		o(&b)
+	}
+
+	return &b
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refresh(b.clock.Now())
+	return b.state
+}
+
+// Allow returns ErrBreakerOpen if the call may not go through. Otherwise the caller has to report the outcome of the
+// call through done, also when the call was cancelled, so a half-open Breaker gets its probe slot back.
+func (b *Breaker) Allow() (done func(outcome Outcome), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.refresh(now)
+
+	switch b.state {
+	case StateOpen:
+		return nil, ErrBreakerOpen
+	case StateHalfOpen:
+		if b.probes >= b.halfOpenRequests {
+			return nil, ErrBreakerOpen
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	var once sync.Once
+	return func(outcome Outcome) {
+		once.Do(func() {
+			b.done(generation, outcome)
+		})
+	}, nil
+}
+
+// Execute calls fn unless the Breaker is open and records its outcome, see OutcomeOf. A panic of fn counts as a
+// failure.
+func (b *Breaker) Execute(fn func() error) error {
+	done, err := b.Allow()
+	if err != nil {
+		return err
+	}
+	outcome := OutcomeFailure
+	defer func() {
+		done(outcome)
+	}()
+	err = fn()
+	outcome = OutcomeOf(err)
+	return err
+}
+
+func (b *Breaker) done(generation int, outcome Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.refresh(now)
+	if generation != b.generation {
+		return
+	}
+
+	switch b.state {
+	case StateHalfOpen:
+		b.probes--
+		switch outcome {
+		case OutcomeIgnored:
+			return
+		case OutcomeFailure:
+			b.setState(StateOpen, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.halfOpenRequests {
+			b.setState(StateClosed, now)
+		}
+	case StateClosed:
+		if outcome == OutcomeIgnored {
+			return
+		}
+		bucket := b.bucket(now)
+		if outcome == OutcomeSuccess {
+			bucket.successes++
+			return
+		}
+		bucket.failures++
+		if b.tripped(now) {
+			b.setState(StateOpen, now)
+		}
+	}
+}
+
+// refresh moves an open Breaker to half-open once the cooldown has passed
+func (b *Breaker) refresh(now time.Time) {
+	if b.state == StateOpen && now.Sub(b.openedAt) >= b.cooldown {
+		b.setState(StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) tripped(now time.Time) bool {
+	successes, failures := b.counts(now)
+	if b.failureThreshold > 0 && failures >= b.failureThreshold {
+		return true
+	}
+	total := successes + failures
+	return b.failureRatio > 0 && total > 0 && total >= b.minRequests &&
+		float64(failures)/float64(total) >= b.failureRatio
+}
+
+func (b *Breaker) setState(state BreakerState, now time.Time) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.probes = 0
+	b.successes = 0
+	switch state {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.buckets = [breakerBuckets]breakerBucket{}
+	}
+	if b.onStateChange != nil && from != state {
+		b.onStateChange(b.name, from, state)
+	}
+}
+
+func (b *Breaker) bucketWidth() int64 {
+	w := int64(b.window) / breakerBuckets
+	if w <= 0 {
+		return 1
+	}
+	return w
+}
+
+func (b *Breaker) bucket(now time.Time) *breakerBucket {
+	epoch := now.UnixNano() / b.bucketWidth()
+	bucket := &b.buckets[epoch%breakerBuckets]
+	if bucket.epoch != epoch {
+		*bucket = breakerBucket{epoch: epoch}
+	}
+	return bucket
+}
+
+func (b *Breaker) counts(now time.Time) (successes int, failures int) {
+	epoch := now.UnixNano() / b.bucketWidth()
+	for _, x := range b.buckets {
+		if epoch-x.epoch < breakerBuckets {
+			successes += x.successes
+			failures += x.failures
+		}
+	}
+	return successes, failures
+}
+
+// Breakers creates a Breaker per name on first use, all with the same options, e.g. one per downstream host
+type Breakers struct {
+	opts     []BreakerOpt
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+func NewBreakers(opts ...BreakerOpt) *Breakers {
+	return &Breakers{
+		opts:     opts,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+func (b *Breakers) Get(name string) *Breaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	breaker, ok := b.breakers[name]
+	if !ok {
+		breaker = NewBreaker(name, b.opts...)
+		b.breakers[name] = breaker
+	}
+	return breaker
+}
@@ -0,0 +1,93 @@
+package retry
+
+import (
+	"context"
+	goErrors "errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreaker(t *testing.T) {
+	var transitions []string
+	b := NewBreaker("downstream",
+		WithFailureThreshold(3),
+		WithCooldown(50*time.Millisecond),
+		WithHalfOpenRequests(2),
+		OnStateChange(func(name string, from BreakerState, to BreakerState) {
+			transitions = append(transitions, from.String()+"->"+to.String())
+		}),
+	)
+	fail := func() error { return goErrors.New("down") }
+	ok := func() error { return nil }
+
+	for i := 0; i < 3; i++ {
+		require.EqualError(t, b.Execute(fail), "down")
+	}
+	require.Equal(t, StateOpen, b.State())
+	require.Equal(t, ErrBreakerOpen, b.Execute(ok))
+
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, StateHalfOpen, b.State())
+	require.Error(t, b.Execute(fail))
+	require.Equal(t, StateOpen, b.State())
+
+	time.Sleep(60 * time.Millisecond)
+	done1, err := b.Allow()
+	require.NoError(t, err)
+	done2, err := b.Allow()
+	require.NoError(t, err)
+	_, err = b.Allow()
+	require.Equal(t, ErrBreakerOpen, err, "more probes than allowed")
+	done1(OutcomeSuccess)
+	done2(OutcomeSuccess)
+	require.Equal(t, StateClosed, b.State())
+
+	require.Equal(t, []string{
+		"closed->open", "open->half-open", "half-open->open", "open->half-open", "half-open->closed",
+	}, transitions)
+}
+
+func TestBreaker_IgnoredOutcomes(t *testing.T) {
+	b := NewBreaker("downstream", WithFailureThreshold(1), WithCooldown(50*time.Millisecond))
+	require.Error(t, b.Execute(func() error { return goErrors.New("down") }))
+	require.Equal(t, StateOpen, b.State())
+	time.Sleep(60 * time.Millisecond)
+
+	// a cancelled probe neither closes nor opens the breaker, but frees its slot
+	require.Equal(t, context.Canceled, b.Execute(func() error { return context.Canceled }))
+	require.Equal(t, StateHalfOpen, b.State())
+
+	// a panicking probe counts as a failure
+	require.Panics(t, func() {
+		_ = b.Execute(func() error { panic("boom") })
+	})
+	require.Equal(t, StateOpen, b.State())
+}
+
+func TestBreaker_FailureRatio(t *testing.T) {
+	b := NewBreaker("downstream", WithFailureThreshold(0), WithFailureRatio(0.5, 4))
+	require.NoError(t, b.Execute(func() error { return nil }))
+	require.Error(t, b.Execute(func() error { return goErrors.New("down") }))
+	require.Error(t, b.Execute(func() error { return goErrors.New("down") }))
+	require.Equal(t, StateClosed, b.State())
+	require.NoError(t, b.Execute(func() error { return nil }))
+	require.Error(t, b.Execute(func() error { return goErrors.New("down") }))
+	require.Equal(t, StateOpen, b.State())
+}
+
+func TestDo_WithBreaker(t *testing.T) {
+	b := NewBreaker("downstream", WithFailureThreshold(2), WithCooldown(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var calls int
+	err := Do(ctx, func() error {
+		calls++
+		return goErrors.New("down")
+	}, WithBreaker(b))
+	require.True(t, goErrors.Is(err, ErrBreakerOpen))
+	require.Equal(t, 2, calls)
+	require.NoError(t, ctx.Err())
+}
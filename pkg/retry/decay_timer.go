@@ -3,6 +3,8 @@ package retry
 import (
 	"math/rand"
 	"time"
+
+	"github.com/unanet/go/v2/pkg/clock"
 )
 
 type DecayTimer struct {
@@ -10,14 +12,24 @@ type DecayTimer struct {
 	backoff  float32
 	duration time.Duration
 	done     chan struct{}
+	clock    clock.Clock
+	rand     *rand.Rand
 }
 
 func NewDecayTimer(d time.Duration, b float32) *DecayTimer {
+	return NewDecayTimerWithClock(d, b, clock.Real, nil)
+}
+
+// NewDecayTimerWithClock creates a DecayTimer that waits on c and draws its jitter from r, the package level
+// source of math/rand is used when r is nil
+func NewDecayTimerWithClock(d time.Duration, b float32, c clock.Clock, r *rand.Rand) *DecayTimer {
 	t := &DecayTimer{
 		C:        make(chan time.Time, 1),
 		backoff:  b,
 		duration: d,
 		done:     make(chan struct{}, 1),
+		clock:    c,
+		rand:     r,
 	}
 	go t.start()
 	return t
@@ -31,12 +43,19 @@ func (t *DecayTimer) start() {
 			return
 		default:
 			if len(t.C) == 0 {
-				t.C <- time.Now()
+				t.C <- t.clock.Now()
 			}
-			time.Sleep(d)
+			t.clock.Sleep(d)
 		}
-		d = time.Duration(float32(d)*t.backoff + float32(d)*rand.Float32()/10.)
+		d = time.Duration(float32(d)*t.backoff + float32(d)*t.float32()/10.)
+	}
+}
+
+func (t *DecayTimer) float32() float32 {
+	if t.rand == nil {
+		return rand.Float32()
 	}
+	return t.rand.Float32()
 }
 
 func (t *DecayTimer) Stop() {
@@ -2,15 +2,48 @@ package retry
 
 import (
 	"context"
+	goErrors "errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
 	"time"
 
-	"github.com/pkg/errors"
+	"github.com/unanet/go/v2/pkg/clock"
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+// ErrMaxAttempts is the Cause of the Error returned by Do once WithMaxAttempts attempts failed
+var ErrMaxAttempts = goErrors.New("maximum number of attempts reached")
+
+type Jitter int
+
+const (
+	// NoJitter waits exactly the exponential delay
+	NoJitter Jitter = iota
+	// FullJitter waits a random duration between 0 and the exponential delay
+	FullJitter
+	// EqualJitter waits half the exponential delay plus a random duration up to the other half
+	EqualJitter
+	// DecorrelatedJitter waits a random duration between the initial delay and three times the previous delay
+	DecorrelatedJitter
+	// DecayJitter adds a random duration of up to 10% to the exponential delay like DecayTimer, it is the default
+	DecayJitter
 )
 
 type retrier struct {
-	backoff  float32
-	interval time.Duration
-	logger   logger
+	backoff     float32
+	interval    time.Duration
+	maxDelay    time.Duration
+	maxAttempts int
+	history     int
+	jitter      Jitter
+	retryIf     func(err error) bool
+	permanent   []error
+	logger      logger
+	breaker     *Breaker
+	clock       clock.Clock
+	rand        *rand.Rand
 }
 
 type logger interface {
@@ -29,36 +62,265 @@ func WithLogger(l logger) Opt {
 	}
 }
 
+// WithInterval sets the backoff factor.
+//
+// Deprecated: despite its name WithInterval does not set an interval, use WithBackoff and WithInitialDelay.
 func WithInterval(b float32) Opt {
+	return WithBackoff(b)
+}
+
+// WithBackoff sets the factor each delay is multiplied by, defaults to 1.4
+func WithBackoff(b float32) Opt {
 	return func(r *retrier) {
 		r.backoff = b
 	}
 }
 
+// WithInitialDelay sets the delay after the first failed attempt, defaults to 200ms
+func WithInitialDelay(d time.Duration) Opt {
+	return func(r *retrier) {
+		r.interval = d
+	}
+}
+
+// WithMaxDelay caps the delay between two attempts
+func WithMaxDelay(d time.Duration) Opt {
+	return func(r *retrier) {
+		r.maxDelay = d
+	}
+}
+
+// WithMaxAttempts makes Do give up with ErrMaxAttempts after n failed attempts
+func WithMaxAttempts(n int) Opt {
+	return func(r *retrier) {
+		r.maxAttempts = n
+	}
+}
+
+// WithJitter sets how the delays are randomized, defaults to DecayJitter. Use NoJitter for exact delays.
+func WithJitter(j Jitter) Opt {
+	return func(r *retrier) {
+		r.jitter = j
+	}
+}
+
+// defaultHistory is the number of errors Do keeps by default
+const defaultHistory = 10
+
+// WithHistory keeps the errors of the last n attempts in the Error returned by Do, defaults to 10. The error of the
+// last attempt is always kept.
+func WithHistory(n int) Opt {
+	return func(r *retrier) {
+		if n < 1 {
+			n = 1
+		}
+		r.history = n
+	}
+}
+
+// WithRetryIf only retries errors fn returns true for, e.g. NotClientError
+func WithRetryIf(fn func(err error) bool) Opt {
+	return func(r *retrier) {
+		r.retryIf = fn
+	}
+}
+
+// WithPermanentErrors stops retrying as soon as an attempt fails with an error that matches one of errs
+func WithPermanentErrors(errs ...error) Opt {
+	return func(r *retrier) {
+		r.permanent = append(r.permanent, errs...)
+	}
+}
+
+// WithClock makes Do wait on c instead of the real clock, e.g. a clock.Fake in tests
+func WithClock(c clock.Clock) Opt {
+	return func(r *retrier) {
+		r.clock = c
+	}
+}
+
+// WithRand draws the jitter from rnd instead of the package level source of math/rand
+func WithRand(rnd *rand.Rand) Opt {
+	return func(r *retrier) {
+		r.rand = rnd
+	}
+}
+
+// WithBreaker runs every attempt through b, Do gives up with ErrBreakerOpen as soon as b is open
+func WithBreaker(b *Breaker) Opt {
+	return func(r *retrier) {
+		r.breaker = b
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err so Do returns it without retrying
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// NotClientError is a WithRetryIf predicate that does not retry RestErrors with a 4xx code
+func NotClientError(err error) bool {
+	var restError errors.RestError
+	if goErrors.As(err, &restError) {
+		return restError.Code < 400 || restError.Code >= 500
+	}
+	return true
+}
+
+// Error is returned by Do when it gives up. Attempts holds the errors of the last attempts, see WithHistory, Count
+// the number of attempts and Cause why Do stopped: the context error, ErrMaxAttempts, or nil when the last error was
+// not retryable. Error unwraps to the last error.
+type Error struct {
+	Attempts []error
+	Count    int
+	Cause    error
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.Cause != nil {
+		fmt.Fprintf(&b, "retry: %v after %d attempts", e.Cause, e.Count)
+	} else {
+		fmt.Fprintf(&b, "retry: permanent error after %d attempts", e.Count)
+	}
+	first := e.Count - len(e.Attempts) + 1
+	for i, err := range e.Attempts {
+		fmt.Fprintf(&b, "; attempt %d: %v", first+i, err)
+	}
+	return b.String()
+}
+
+func (e *Error) Unwrap() error {
+	if len(e.Attempts) == 0 {
+		return nil
+	}
+	return e.Attempts[len(e.Attempts)-1]
+}
+
+// Is lets errors.Is match the Cause as well as the last error
+func (e *Error) Is(target error) bool {
+	return e.Cause != nil && goErrors.Is(e.Cause, target)
+}
+
+// Do calls fn until it succeeds, waiting an exponentially growing, slightly jittered delay between attempts. It gives
+// up when ctx is done, when the maximum number of attempts is reached or when an error is not retryable, and then
+// returns an *Error.
 func Do(ctx context.Context, fn func() error, opts ...Opt) error {
 	r := retrier{
 		backoff:  1.4,
 		interval: time.Millisecond * 200,
+		jitter:   DecayJitter,
+		history:  defaultHistory,
 		logger:   noopLogger{},
+		clock:    clock.Real,
 	}
 	for _, o := range opts {
 		o(&r)
 	}
 
-	t := NewDecayTimer(r.interval, r.backoff)
-	defer t.Stop()
+	var attempts []error
+	var delay time.Duration
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return &Error{Attempts: attempts, Count: n - 1, Cause: err}
+		}
 
-	var err error
-	for {
+		err := r.attempt(fn)
+		if err == nil {
+			return nil
+		}
+		if len(attempts) >= r.history {
+			attempts = append(attempts[:0], attempts[len(attempts)-r.history+1:]...)
+		}
+		attempts = append(attempts, err)
+		r.logger.Printf("retrier encountered an error: %v", err)
+
+		if !r.retryable(err) {
+			return &Error{Attempts: attempts, Count: n}
+		}
+		if r.maxAttempts > 0 && n >= r.maxAttempts {
+			return &Error{Attempts: attempts, Count: n, Cause: ErrMaxAttempts}
+		}
+
+		delay = r.delay(n, delay)
+		t := r.clock.NewTimer(delay)
 		select {
 		case <-ctx.Done():
-			return errors.Wrap(err, "return context timeout exceeded")
-		case <-t.C:
-			err = fn()
-			if err == nil {
-				return nil
-			}
-			r.logger.Printf("retrier encountered an error: %v", err)
+			t.Stop()
+			return &Error{Attempts: attempts, Count: n, Cause: ctx.Err()}
+		case <-t.C():
+		}
+	}
+}
+
+func (r *retrier) attempt(fn func() error) error {
+	if r.breaker == nil {
+		return fn()
+	}
+	return r.breaker.Execute(fn)
+}
+
+func (r *retrier) retryable(err error) bool {
+	var p permanentError
+	if goErrors.As(err, &p) || goErrors.Is(err, ErrBreakerOpen) {
+		return false
+	}
+	for _, x := range r.permanent {
+		if goErrors.Is(err, x) {
+			return false
 		}
 	}
+	return r.retryIf == nil || r.retryIf(err)
+}
+
+// delay returns how long to wait after the nth failed attempt, prev is the delay after the previous attempt
+func (r *retrier) delay(n int, prev time.Duration) time.Duration {
+	d := float64(r.interval) * math.Pow(float64(r.backoff), float64(n-1))
+	if r.maxDelay > 0 && d > float64(r.maxDelay) {
+		d = float64(r.maxDelay)
+	}
+
+	switch r.jitter {
+	case FullJitter:
+		d = r.float64() * d
+	case EqualJitter:
+		d = d/2 + r.float64()*d/2
+	case DecorrelatedJitter:
+		if prev < r.interval {
+			prev = r.interval
+		}
+		d = float64(r.interval) + r.float64()*float64(3*prev-r.interval)
+		if r.maxDelay > 0 && d > float64(r.maxDelay) {
+			d = float64(r.maxDelay)
+		}
+	case DecayJitter:
+		d += r.float64() * d / 10
+		if r.maxDelay > 0 && d > float64(r.maxDelay) {
+			d = float64(r.maxDelay)
+		}
+	}
+
+	return time.Duration(d)
+}
+
+func (r *retrier) float64() float64 {
+	if r.rand == nil {
+		return rand.Float64()
+	}
+	return r.rand.Float64()
 }
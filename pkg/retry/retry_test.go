@@ -0,0 +1,193 @@
+package retry
+
+import (
+	"context"
+	goErrors "errors"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/unanet/go/v2/pkg/clock"
+	"github.com/unanet/go/v2/pkg/errors"
+)
+
+func TestDo_MaxAttempts(t *testing.T) {
+	var calls int
+	err := Do(context.Background(), func() error {
+		calls++
+		return goErrors.New("down")
+	}, WithMaxAttempts(3), WithInitialDelay(time.Millisecond))
+
+	require.Equal(t, 3, calls)
+	require.True(t, goErrors.Is(err, ErrMaxAttempts))
+	var retryErr *Error
+	require.True(t, goErrors.As(err, &retryErr))
+	require.Len(t, retryErr.Attempts, 3)
+	require.EqualError(t, err, "retry: maximum number of attempts reached after 3 attempts; "+
+		"attempt 1: down; attempt 2: down; attempt 3: down")
+}
+
+func TestDo_History(t *testing.T) {
+	var calls int
+	err := Do(context.Background(), func() error {
+		calls++
+		return fmt.Errorf("down %d", calls)
+	}, WithMaxAttempts(5), WithHistory(2), WithInitialDelay(time.Millisecond))
+
+	var retryErr *Error
+	require.True(t, goErrors.As(err, &retryErr))
+	require.Equal(t, 5, retryErr.Count)
+	require.Len(t, retryErr.Attempts, 2)
+	require.EqualError(t, err, "retry: maximum number of attempts reached after 5 attempts; "+
+		"attempt 4: down 4; attempt 5: down 5")
+	require.EqualError(t, goErrors.Unwrap(err), "down 5")
+}
+
+func TestDo_PermanentErrors(t *testing.T) {
+	errNotFound := goErrors.New("not found")
+	tests := []struct {
+		name string
+		err  error
+		opts []Opt
+	}{
+		{name: "permanent", err: Permanent(goErrors.New("invalid"))},
+		{name: "errors.Is", err: errors.Wrap(errNotFound), opts: []Opt{WithPermanentErrors(errNotFound)}},
+		{name: "client error", err: errors.NewRestError(400, "Bad Request"), opts: []Opt{WithRetryIf(NotClientError)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			err := Do(context.Background(), func() error {
+				calls++
+				return tt.err
+			}, tt.opts...)
+			require.Equal(t, 1, calls)
+			require.True(t, goErrors.Is(err, tt.err))
+		})
+	}
+
+	var calls int
+	err := Do(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return errors.NewRestError(503, "Service Unavailable")
+		}
+		return nil
+	}, WithRetryIf(NotClientError), WithInitialDelay(time.Millisecond))
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestDo_Context(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := Do(ctx, func() error {
+		return goErrors.New("down")
+	}, WithInitialDelay(10*time.Millisecond))
+	require.True(t, goErrors.Is(err, context.DeadlineExceeded))
+	require.EqualError(t, goErrors.Unwrap(err), "down")
+}
+
+func TestDo_Backoff(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+
+	var attempts []time.Duration
+	result := make(chan error)
+	go func() {
+		result <- Do(context.Background(), func() error {
+			attempts = append(attempts, c.Now().Sub(start))
+			return goErrors.New("down")
+		}, WithClock(c), WithJitter(NoJitter), WithBackoff(2), WithInitialDelay(100*time.Millisecond),
+			WithMaxDelay(time.Second), WithMaxAttempts(6))
+	}()
+
+	for i := 0; i < 5; i++ {
+		c.BlockUntil(1)
+		c.Advance(time.Second)
+	}
+	require.True(t, goErrors.Is(<-result, ErrMaxAttempts))
+	require.Equal(t, []time.Duration{
+		0, time.Second, 2 * time.Second, 3 * time.Second, 4 * time.Second, 5 * time.Second,
+	}, attempts)
+
+	// advancing by exactly the expected delay has to trigger the next attempt, one nanosecond less must not
+	c = clock.NewFake(start)
+	attempts = nil
+	go func() {
+		result <- Do(context.Background(), func() error {
+			attempts = append(attempts, c.Now().Sub(start))
+			return goErrors.New("down")
+		}, WithClock(c), WithJitter(NoJitter), WithBackoff(2), WithInitialDelay(100*time.Millisecond),
+			WithMaxDelay(time.Second), WithMaxAttempts(6))
+	}()
+	for _, d := range []time.Duration{100, 200, 400, 800, 1000} {
+		c.BlockUntil(1)
+		c.Advance(d*time.Millisecond - 1)
+		c.Advance(1)
+	}
+	<-result
+	require.Equal(t, []time.Duration{
+		0, 100 * time.Millisecond, 300 * time.Millisecond, 700 * time.Millisecond, 1500 * time.Millisecond,
+		2500 * time.Millisecond,
+	}, attempts)
+}
+
+func TestDo_Jitter(t *testing.T) {
+	for _, j := range []Jitter{FullJitter, EqualJitter, DecorrelatedJitter, DecayJitter} {
+		// the same seed has to produce the same delays
+		expected := retrier{backoff: 2, interval: 100 * time.Millisecond, maxDelay: time.Second, jitter: j,
+			rand: rand.New(rand.NewSource(1))}
+		var want []time.Duration
+		var prev time.Duration
+		for n := 1; n <= 5; n++ {
+			prev = expected.delay(n, prev)
+			require.True(t, prev >= 0 && prev <= time.Second, "delay %s out of range", prev)
+			want = append(want, prev)
+		}
+
+		start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		c := clock.NewFake(start)
+		var attempts []time.Time
+		result := make(chan error)
+		go func() {
+			result <- Do(context.Background(), func() error {
+				attempts = append(attempts, c.Now())
+				return goErrors.New("down")
+			}, WithClock(c), WithRand(rand.New(rand.NewSource(1))), WithJitter(j), WithBackoff(2),
+				WithInitialDelay(100*time.Millisecond), WithMaxDelay(time.Second), WithMaxAttempts(6))
+		}()
+		for _, d := range want {
+			c.BlockUntil(1)
+			c.Advance(d)
+		}
+		<-result
+
+		require.Len(t, attempts, 6)
+		for i, d := range want {
+			require.Equal(t, d, attempts[i+1].Sub(attempts[i]))
+		}
+	}
+}
+
+func TestDecayTimer(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	timer := NewDecayTimerWithClock(time.Second, 2, c, rand.New(rand.NewSource(1)))
+	defer timer.Stop()
+
+	expected := rand.New(rand.NewSource(1))
+	d := time.Second
+	now := start
+	for i := 0; i < 4; i++ {
+		require.Equal(t, now, <-timer.C)
+		c.BlockUntil(1)
+		c.Advance(d)
+		now = now.Add(d)
+		d = time.Duration(float32(d)*2 + float32(d)*expected.Float32()/10.)
+	}
+}